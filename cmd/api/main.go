@@ -1,12 +1,26 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"backend/internal/auth"
+	"backend/internal/blob"
+	"backend/internal/config"
 	"backend/internal/db"
 	"backend/internal/handlers"
+	"backend/internal/logging"
+	"backend/internal/metrics"
 	"backend/internal/middleware"
+	"backend/internal/ratelimit"
+	"backend/internal/tracing"
 
 	"github.com/joho/godotenv"
 )
@@ -14,42 +28,197 @@ import (
 func main() {
 	godotenv.Load()
 
-	if err := db.Connect(); err != nil {
-		log.Fatal(err)
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		fatal("Invalid configuration", err)
 	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/login", handlers.Login)
-	mux.Handle("/protected", middleware.Auth(http.HandlerFunc(handlers.Protected)))
+	logger, err := logging.New(os.Stderr, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	if err := db.Connect(ctx, cfg.Database); err != nil {
+		fatal("Failed to connect to the database", err)
+	}
+	metrics.RegisterDB(db.Conn)
+	if err := db.Migrate(db.Conn); err != nil {
+		fatal("Failed to migrate the database", err)
+	}
+
+	blobs, err := blob.Open(cfg.Blob)
+	if err != nil {
+		fatal("Failed to open the blob store", err)
+	}
 
-	mux.HandleFunc("/user/{id}", handlers.GetUser(db.Conn))
-	mux.HandleFunc("/user/{id}/image", handlers.GetUserImage(db.Conn))
-	mux.Handle("/edituser", middleware.Auth(handlers.EditUser(db.Conn)))
+	tokens := auth.New(cfg.Auth.Secret, cfg.Auth.TokenTTL)
+	if err := tokens.Reload(ctx, db.Conn); errors.Is(err, auth.ErrUnreadableKey) {
+		slog.Warn("Skipped signing keys", "err", err)
+	} else if err != nil {
+		fatal("Failed to load signing keys", err)
+	}
+	go reloadKeys(ctx, tokens)
 
-	mux.Handle("/topics", middleware.Auth(handlers.GetTopics(db.Conn)))
-	mux.HandleFunc("/topics/{name}", handlers.GetTopic(db.Conn))
-	mux.HandleFunc("/topics/{name}/posts", handlers.GetPostsByTopic(db.Conn))
-	mux.HandleFunc("/topics/{name}/image", handlers.GetTopicImage(db.Conn))
+	go pruneMedia(ctx, blobs, cfg.Limits.MediaMaxAge)
+	go pruneIdempotencyKeys(ctx, cfg.Limits.IdempotencyTTL)
+	go purgeAccounts(ctx, blobs, cfg.Limits.DeletionGrace)
 
-	mux.Handle("/addtopic", middleware.Auth(handlers.AddTopic(db.Conn)))
-	mux.Handle("/edittopic", middleware.Auth(handlers.EditTopic(db.Conn)))
-	mux.Handle("/deletetopic", middleware.Auth(handlers.DeleteTopic(db.Conn)))
+	limits, err := ratelimit.Open(cfg.RateLimit.Backend, db.Conn)
+	if err != nil {
+		fatal("Failed to open the rate limit store", err)
+	}
+	if pg, ok := limits.(*ratelimit.Postgres); ok {
+		go pruneRateLimits(ctx, pg)
+	}
+	limiter := middleware.NewRateLimiter(limits, tokens, cfg.Server.TrustedProxies)
 
-	mux.HandleFunc("/posts/{id}", handlers.GetPost(db.Conn))
-	mux.HandleFunc("/posts/{id}/comments", handlers.GetCommentsByPost(db.Conn))
+	mux, _ := routes(tokens, blobs, limiter, cfg.Limits.DeletionGrace)
 
-	mux.Handle("/votepost", middleware.Auth(handlers.VotePost(db.Conn)))
+	var handler http.Handler = middleware.RecordRoute(mux)
+	handler = middleware.Idempotency(db.Conn, tokens, cfg.Limits.IdempotencyTTL, cfg.Limits.IdempotencyLease, handler)
+	handler = middleware.LimitBody(cfg.Limits.MaxBodyBytes, handler)
+	handler = middleware.CORS(cfg.CORS.Origins, cfg.CORS.MaxAge, handler)
+	handler = middleware.SecurityHeaders(cfg.Server.HSTSMaxAge, handler)
+	handler = middleware.Metrics(handler)
+	handler = middleware.AccessLog(handler)
+	handler = middleware.Trace(handler)
+	handler = middleware.RequestID(handler)
 
-	mux.Handle("/addpost", middleware.Auth(handlers.AddPost(db.Conn)))
-	mux.Handle("/editpost", middleware.Auth(handlers.EditPost(db.Conn)))
-	mux.Handle("/deletepost", middleware.Auth(handlers.DeletePost(db.Conn)))
+	// Requests run under their own context rather than ctx, so a shutdown
+	// lets them finish. Only those still running at the deadline, such as
+	// streams, are cancelled.
+	requests, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
 
-	mux.Handle("/addcomment", middleware.Auth(handlers.AddComment(db.Conn)))
-	mux.Handle("/editcomment", middleware.Auth(handlers.EditComment(db.Conn)))
-	mux.Handle("/deletecomment", middleware.Auth(handlers.DeleteComment(db.Conn)))
+	srv := &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		BaseContext:       func(net.Listener) context.Context { return requests },
+	}
 
-	handler := middleware.CORS(mux)
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("API running", "addr", cfg.ListenAddr)
+		serveErr <- srv.ListenAndServe()
+	}()
 
-	log.Println("API running on :8080")
-	log.Fatal(http.ListenAndServe(":8080", handler))
+	select {
+	case err := <-serveErr:
+		fatal("Server failed", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("Shutting down", "timeout", cfg.Server.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Requests still running at the deadline were cut off", "err", err)
+		cancelRequests()
+		srv.Close()
+	}
+
+	if err := db.Conn.Close(); err != nil {
+		slog.Error("Failed to close database", "err", err)
+	}
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("Failed to flush traces", "err", err)
+	}
+	slog.Info("Stopped")
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+// reloadKeys picks up signing keys rotated with forumctl.
+func reloadKeys(ctx context.Context, tokens *auth.Tokens) {
+	every(ctx, auth.KeyReloadInterval, func() {
+		// Unreadable keys were reported at startup.
+		if err := tokens.Reload(ctx, db.Conn); err != nil && !errors.Is(err, auth.ErrUnreadableKey) {
+			slog.Error("Failed to reload signing keys", "err", err)
+		}
+	})
+}
+
+// pruneMedia periodically removes uploads that never made it into a post.
+func pruneMedia(ctx context.Context, blobs blob.Store, maxAge time.Duration) {
+	every(ctx, time.Hour, func() {
+		n, err := handlers.PruneMedia(db.Conn, blobs, maxAge)
+		if err != nil {
+			slog.Error("Failed to prune media", "err", err)
+		} else if n > 0 {
+			slog.Info("Pruned unattached media", "count", n)
+		}
+	})
+}
+
+// purgeAccounts periodically deletes the accounts whose grace period has
+// passed.
+func purgeAccounts(ctx context.Context, blobs blob.Store, grace time.Duration) {
+	every(ctx, time.Hour, func() {
+		n, err := handlers.PurgeAccounts(db.Conn, blobs, grace)
+		if err != nil {
+			slog.Error("Failed to delete accounts", "err", err)
+		} else if n > 0 {
+			slog.Info("Deleted accounts", "count", n)
+		}
+	})
+}
+
+// pruneIdempotencyKeys periodically forgets responses that can no longer be
+// replayed.
+func pruneIdempotencyKeys(ctx context.Context, ttl time.Duration) {
+	every(ctx, time.Hour, func() {
+		n, err := middleware.PruneIdempotencyKeys(db.Conn, ttl)
+		if err != nil {
+			slog.Error("Failed to prune idempotency keys", "err", err)
+		} else if n > 0 {
+			slog.Info("Pruned idempotency keys", "count", n)
+		}
+	})
+}
+
+// pruneRateLimits periodically deletes rate limit buckets that have filled
+// up again.
+func pruneRateLimits(ctx context.Context, limits *ratelimit.Postgres) {
+	every(ctx, 10*time.Minute, func() {
+		n, err := limits.Prune(ctx)
+		if err != nil {
+			slog.Error("Failed to prune rate limits", "err", err)
+		} else if n > 0 {
+			slog.Info("Pruned rate limits", "count", n)
+		}
+	})
+}
+
+// every runs job at each interval until ctx is cancelled.
+func every(ctx context.Context, interval time.Duration, job func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			job()
+		}
+	}
 }
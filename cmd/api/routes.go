@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/blob"
+	"backend/internal/db"
+	"backend/internal/handlers"
+	"backend/internal/metrics"
+	"backend/internal/middleware"
+	"backend/internal/openapi"
+	"backend/internal/ratelimit"
+)
+
+// Rate limits for the routes one script could flood the site through.
+// Routes that share a policy share its quota.
+var (
+	loginLimit   = ratelimit.Policy{Name: "login", Burst: 10, Period: 15 * time.Minute}
+	postLimit    = ratelimit.Policy{Name: "post", Burst: 5, Period: 10 * time.Minute}
+	commentLimit = ratelimit.Policy{Name: "comment", Burst: 20, Period: 10 * time.Minute}
+	voteLimit    = ratelimit.Policy{Name: "vote", Burst: 60, Period: time.Minute}
+	exportLimit  = ratelimit.Policy{Name: "export", Burst: 3, Period: time.Hour}
+	uploadLimit  = ratelimit.Policy{Name: "upload", Burst: 30, Period: 10 * time.Minute}
+)
+
+// rateLimits assigns policies to routes by pattern, both /api/v1 ones
+// (relative to handlers.APIPrefix) and legacy ones.
+var rateLimits = map[string]ratelimit.Policy{
+	"POST /login":               loginLimit,
+	"POST /posts":               postLimit,
+	"POST /posts/{id}/comments": commentLimit,
+	"PUT /posts/{id}/vote":      voteLimit,
+	"DELETE /posts/{id}/vote":   voteLimit,
+	"GET /users/me/export":      exportLimit,
+	"POST /media":               uploadLimit,
+
+	"/login":      loginLimit,
+	"/addpost":    postLimit,
+	"/addcomment": commentLimit,
+	"/votepost":   voteLimit,
+}
+
+// routes builds the API and returns it together with every pattern it
+// serves. deletionGrace is how long account deletions can be cancelled.
+func routes(tokens *auth.Tokens, blobs blob.Store, limiter *middleware.RateLimiter, deletionGrace time.Duration) (*http.ServeMux, []string) {
+	mux := http.NewServeMux()
+
+	var patterns []string
+	handle := func(pattern string, h http.Handler) {
+		mux.Handle(pattern, h)
+		patterns = append(patterns, pattern)
+	}
+	v1 := func(pattern string, h http.Handler) {
+		if p, ok := rateLimits[pattern]; ok {
+			h = limiter.Limit(p, h)
+		}
+		method, path, _ := strings.Cut(pattern, " ")
+		handle(method+" "+handlers.APIPrefix+path, h)
+	}
+	requireAuth := func(h http.Handler) http.Handler {
+		return middleware.Auth(db.Conn, tokens, h)
+	}
+
+	v1("POST /login", handlers.Login(db.Conn, tokens))
+
+	v1("GET /users/{id}", handlers.GetUser(db.Conn))
+	v1("GET /users/{id}/image", handlers.GetUserImage(db.Conn, blobs))
+	v1("PATCH /users/me", requireAuth(handlers.EditUser(db.Conn, tokens, blobs)))
+	v1("GET /users/me/export", requireAuth(handlers.ExportUserData(db.Conn, tokens, blobs)))
+	v1("GET /users/me/deletion", requireAuth(handlers.GetAccountDeletion(db.Conn, tokens, deletionGrace)))
+	v1("POST /users/me/deletion", requireAuth(handlers.RequestAccountDeletion(db.Conn, tokens, deletionGrace)))
+	v1("DELETE /users/me/deletion", requireAuth(handlers.CancelAccountDeletion(db.Conn, tokens)))
+
+	v1("GET /topics", requireAuth(handlers.GetTopics(db.Conn)))
+	v1("POST /topics", requireAuth(handlers.AddTopic(db.Conn, blobs)))
+	v1("GET /topics/{name}", handlers.GetTopic(db.Conn))
+	v1("PATCH /topics/{name}", requireAuth(handlers.EditTopic(db.Conn, blobs)))
+	v1("DELETE /topics/{name}", requireAuth(handlers.DeleteTopic(db.Conn, blobs)))
+	v1("GET /topics/{name}/image", handlers.GetTopicImage(db.Conn, blobs))
+	v1("GET /topics/{name}/posts", handlers.GetPostsByTopic(db.Conn, tokens))
+
+	v1("POST /posts", requireAuth(handlers.AddPost(db.Conn, tokens)))
+	v1("GET /posts/{id}", handlers.GetPost(db.Conn, tokens))
+	v1("PATCH /posts/{id}", requireAuth(handlers.EditPost(db.Conn, tokens)))
+	v1("DELETE /posts/{id}", requireAuth(handlers.DeletePost(db.Conn, tokens)))
+	v1("PUT /posts/{id}/vote", requireAuth(handlers.VotePost(db.Conn, tokens)))
+	v1("DELETE /posts/{id}/vote", requireAuth(handlers.VotePost(db.Conn, tokens)))
+	v1("GET /posts/{id}/comments", handlers.GetCommentsByPost(db.Conn))
+	v1("POST /posts/{id}/comments", requireAuth(handlers.AddComment(db.Conn, tokens)))
+
+	v1("GET /comments/{id}", handlers.GetComment(db.Conn))
+	v1("PATCH /comments/{id}", requireAuth(handlers.EditComment(db.Conn, tokens)))
+	v1("DELETE /comments/{id}", requireAuth(handlers.DeleteComment(db.Conn, tokens)))
+
+	v1("POST /media", requireAuth(handlers.UploadMedia(db.Conn, tokens, blobs)))
+	v1("GET /media/{id}", handlers.GetMedia(db.Conn, tokens, blobs))
+
+	handle("GET /healthz", http.HandlerFunc(handlers.Healthz))
+	handle("GET /readyz", handlers.Readyz(db.Conn, blobs))
+	handle("GET /version", http.HandlerFunc(handlers.Version))
+	handle("GET /metrics", metrics.Handler())
+
+	handle("GET /openapi.json", openapi.Spec())
+	handle("GET /docs", openapi.Docs())
+	handle("GET /docs/{file}", openapi.Docs())
+
+	legacyRoutes(handle, tokens, blobs, limiter)
+
+	return mux, patterns
+}
+
+// legacyRoutes keeps the pre-v1 routes working for existing clients. They
+// accept any method and take IDs from the request body.
+func legacyRoutes(handle func(string, http.Handler), tokens *auth.Tokens, blobs blob.Store, limiter *middleware.RateLimiter) {
+	legacy := func(pattern string, h http.Handler) {
+		if p, ok := rateLimits[pattern]; ok {
+			h = limiter.Limit(p, h)
+		}
+		handle(pattern, middleware.Deprecated(h))
+	}
+	requireAuth := func(h http.Handler) http.Handler {
+		return middleware.Auth(db.Conn, tokens, h)
+	}
+	// Edits and deletes used to succeed without doing anything when the
+	// record did not exist or was not the user's.
+	unmatched := []int{http.StatusForbidden, http.StatusNotFound}
+	created := func(h http.Handler, ignored ...int) http.Handler {
+		return middleware.LegacyStatus(http.StatusCreated, ignored, h)
+	}
+	accepted := func(h http.Handler, ignored ...int) http.Handler {
+		return middleware.LegacyStatus(http.StatusAccepted, ignored, h)
+	}
+
+	legacy("/login", handlers.Login(db.Conn, tokens))
+	legacy("/protected", requireAuth(http.HandlerFunc(handlers.Protected)))
+
+	legacy("/user/{id}", handlers.GetUser(db.Conn))
+	legacy("/user/{id}/image", handlers.GetUserImage(db.Conn, blobs))
+	legacy("/edituser", accepted(requireAuth(handlers.EditUser(db.Conn, tokens, blobs))))
+
+	legacy("/topics", requireAuth(handlers.GetTopics(db.Conn)))
+	legacy("/topics/{name}", handlers.GetTopic(db.Conn))
+	legacy("/topics/{name}/posts", handlers.GetPostsByTopic(db.Conn, tokens))
+	legacy("/topics/{name}/image", handlers.GetTopicImage(db.Conn, blobs))
+
+	legacy("/addtopic", created(requireAuth(handlers.AddTopic(db.Conn, blobs))))
+	legacy("/edittopic", accepted(requireAuth(handlers.EditTopic(db.Conn, blobs)), unmatched...))
+	legacy("/deletetopic", accepted(requireAuth(handlers.DeleteTopic(db.Conn, blobs)), unmatched...))
+
+	legacy("/posts/{id}", handlers.GetPost(db.Conn, tokens))
+	legacy("/posts/{id}/comments", handlers.GetCommentsByPost(db.Conn))
+
+	legacy("/votepost", requireAuth(handlers.VotePost(db.Conn, tokens)))
+
+	legacy("/addpost", created(requireAuth(handlers.AddPost(db.Conn, tokens))))
+	legacy("/editpost", created(requireAuth(handlers.EditPost(db.Conn, tokens)), unmatched...))
+	legacy("/deletepost", created(requireAuth(handlers.DeletePost(db.Conn, tokens)), unmatched...))
+
+	legacy("/addcomment", accepted(requireAuth(handlers.AddComment(db.Conn, tokens))))
+	legacy("/editcomment", accepted(requireAuth(handlers.EditComment(db.Conn, tokens)), unmatched...))
+	legacy("/deletecomment", accepted(requireAuth(handlers.DeleteComment(db.Conn, tokens)), unmatched...))
+}
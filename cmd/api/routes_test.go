@@ -0,0 +1,97 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/handlers"
+	"backend/internal/middleware"
+	"backend/internal/models"
+	"backend/internal/openapi"
+	"backend/internal/ratelimit"
+)
+
+// documentedModels are the request and response models that openapi.json
+// must describe in full, keyed by schema name.
+var documentedModels = map[string]any{
+	"User":            models.User{},
+	"Topic":           models.Topic{},
+	"Post":            models.Post{},
+	"Attachment":      models.Attachment{},
+	"Comment":         models.Comment{},
+	"AccountDeletion": models.AccountDeletion{},
+
+	"LoginRequest":      handlers.LoginRequest{},
+	"TopicRequest":      handlers.TopicRequest{},
+	"CreatePostRequest": handlers.CreatePostRequest{},
+	"UpdatePostRequest": handlers.UpdatePostRequest{},
+	"CommentRequest":    handlers.CommentRequest{},
+	"VoteRequest":       handlers.VoteRequest{},
+	"ImageRequest":      handlers.ImageRequest{},
+}
+
+// undocumented are the routes outside /api/v1, which openapi.json does
+// not describe: operational endpoints, the documentation itself and the
+// deprecated legacy routes.
+var undocumented = []string{
+	"GET /healthz",
+	"GET /readyz",
+	"GET /version",
+	"GET /metrics",
+	"GET /openapi.json",
+	"GET /docs",
+	"GET /docs/{file}",
+
+	"/login",
+	"/protected",
+	"/user/{id}",
+	"/user/{id}/image",
+	"/edituser",
+	"/topics",
+	"/topics/{name}",
+	"/topics/{name}/posts",
+	"/topics/{name}/image",
+	"/addtopic",
+	"/edittopic",
+	"/deletetopic",
+	"/posts/{id}",
+	"/posts/{id}/comments",
+	"/votepost",
+	"/addpost",
+	"/editpost",
+	"/deletepost",
+	"/addcomment",
+	"/editcomment",
+	"/deletecomment",
+}
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	limits, err := ratelimit.Open("memory", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := auth.New("secret", time.Hour)
+	_, patterns := routes(tokens, nil, middleware.NewRateLimiter(limits, tokens, nil), time.Hour)
+
+	var v1 []string
+	for _, pattern := range patterns {
+		method, path, ok := strings.Cut(pattern, " ")
+		if rel, isV1 := strings.CutPrefix(path, handlers.APIPrefix); ok && isV1 {
+			v1 = append(v1, method+" "+rel)
+		} else if !slices.Contains(undocumented, pattern) {
+			t.Errorf("route %q is outside %s and not listed as undocumented", pattern, handlers.APIPrefix)
+		}
+	}
+	for _, pattern := range undocumented {
+		if !slices.Contains(patterns, pattern) {
+			t.Errorf("undocumented route %q is not served", pattern)
+		}
+	}
+
+	if err := openapi.Check(v1, documentedModels); err != nil {
+		t.Error(err)
+	}
+}
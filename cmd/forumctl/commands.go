@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/archive"
+	"backend/internal/blob"
+	"backend/internal/handlers"
+	"backend/internal/models"
+	"backend/internal/problem"
+	"backend/internal/seed"
+	"backend/internal/store"
+)
+
+type command struct {
+	name string // one or more words
+	args string
+	help string
+	run  func(ctx context.Context, e *env, args []string) error
+}
+
+var commands = []command{
+	{"topics list", "", "list topics", topicsList},
+	{"topics create", "NAME [DESCRIPTION]", "create a topic", topicsCreate},
+	{"topics rename", "NAME NEW_NAME", "rename a topic, keeping its posts", topicsRename},
+	{"topics delete", "NAME", "delete a topic and all of its posts", topicsDelete},
+
+	{"roles list", "", "list users holding roles", rolesList},
+	{"roles grant", "USER ROLE", "give a user the admin or moderator role", rolesGrant},
+	{"roles revoke", "USER ROLE", "take a role away from a user", rolesRevoke},
+
+	{"users ban", "USER [REASON]", "keep a user from logging in or writing", usersBan},
+	{"users unban", "USER", "lift a ban", usersUnban},
+	{"users delete", "USER", "delete an account now, handing its posts and comments to [deleted user]", usersDelete},
+
+	{"posts delete", "ID", "hide a post and its comments", setDeleted("post", store.SetPostDeleted, true)},
+	{"posts restore", "ID", "show a deleted post again", setDeleted("post", store.SetPostDeleted, false)},
+	{"comments delete", "ID", "hide a comment", setDeleted("comment", store.SetCommentDeleted, true)},
+	{"comments restore", "ID", "show a deleted comment again", setDeleted("comment", store.SetCommentDeleted, false)},
+
+	{"keys list", "", "list the keys that verify access tokens", keysList},
+	{"keys rotate", "", "add a new signing key and delete retired ones", keysRotate},
+
+	{"stats", "", "print row counts", stats},
+	{"seed", "[-seed N] [-users N] [-topics N] [-posts N] [-comments N] [-votes N] [-days N] [-end DATE]",
+		"fill an empty database with generated data", seedData},
+	{"export", "DIR", "write all forum data and images to a new directory", exportData},
+	{"import", "DIR", "load a directory written by export into an empty database", importData},
+}
+
+func topicsList(ctx context.Context, e *env, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	topics, err := store.Topics(ctx, e.db)
+	if err != nil {
+		return err
+	}
+	return e.print(topics, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tDESCRIPTION")
+		for _, t := range topics {
+			fmt.Fprintf(w, "%s\t%s\n", t.Name, t.Description)
+		}
+	})
+}
+
+func topicsCreate(ctx context.Context, e *env, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return errUsage
+	}
+	var description string
+	if len(args) == 2 {
+		description = args[1]
+	}
+	name, description, err := handlers.ValidateTopic(args[0], description)
+	if err != nil {
+		return invalid(err)
+	}
+
+	t, err := store.CreateTopic(ctx, e.db, name, description, nil)
+	if errors.Is(err, store.ErrExists) {
+		return fmt.Errorf("topic %s already exists", name)
+	} else if err != nil {
+		return err
+	}
+	return e.print(t, func(w io.Writer) {
+		fmt.Fprintf(w, "Created topic %s\n", t.Name)
+	})
+}
+
+func topicsRename(ctx context.Context, e *env, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	newName, _, err := handlers.ValidateTopic(args[1], "")
+	if err != nil {
+		return invalid(err)
+	}
+
+	t, err := store.RenameTopic(ctx, e.db, args[0], newName)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("no topic named %s", args[0])
+	} else if errors.Is(err, store.ErrExists) {
+		return fmt.Errorf("topic %s already exists", newName)
+	} else if err != nil {
+		return err
+	}
+	return e.print(t, func(w io.Writer) {
+		fmt.Fprintf(w, "Renamed topic %s to %s\n", args[0], t.Name)
+	})
+}
+
+func topicsDelete(ctx context.Context, e *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	imageKey, err := store.DeleteTopic(ctx, e.db, args[0])
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("no topic named %s", args[0])
+	} else if err != nil {
+		return err
+	}
+
+	if imageKey.Valid {
+		blobs, err := blob.Open(e.cfg.Blob)
+		if err != nil {
+			return fmt.Errorf("topic deleted, but opening the blob store to delete its image failed: %w", err)
+		}
+		handlers.DiscardImage(ctx, blobs, imageKey)
+	}
+
+	return e.print(map[string]string{"deleted": args[0]}, func(w io.Writer) {
+		fmt.Fprintf(w, "Deleted topic %s\n", args[0])
+	})
+}
+
+// grant is printed by the role commands.
+type grant struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+func rolesList(ctx context.Context, e *env, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	grants, err := store.Grants(ctx, e.db)
+	if err != nil {
+		return err
+	}
+	return e.print(grants, func(w io.Writer) {
+		fmt.Fprintln(w, "USER\tID\tROLE\tGRANTED")
+		for _, g := range grants {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", g.Username, g.UserID, g.Role, formatTime(g.GrantedAt))
+		}
+	})
+}
+
+func rolesGrant(ctx context.Context, e *env, args []string) error {
+	u, role, err := userAndRole(ctx, e, args)
+	if err != nil {
+		return err
+	}
+	if err := store.GrantRole(ctx, e.db, u.ID, role); err != nil {
+		return err
+	}
+	return e.print(grant{u.ID, u.Username, role}, func(w io.Writer) {
+		fmt.Fprintf(w, "Granted %s the %s role\n", u.Username, role)
+	})
+}
+
+func rolesRevoke(ctx context.Context, e *env, args []string) error {
+	u, role, err := userAndRole(ctx, e, args)
+	if err != nil {
+		return err
+	}
+	err = store.RevokeRole(ctx, e.db, u.ID, role)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("%s does not have the %s role", u.Username, role)
+	} else if err != nil {
+		return err
+	}
+	return e.print(grant{u.ID, u.Username, role}, func(w io.Writer) {
+		fmt.Fprintf(w, "Revoked the %s role from %s\n", role, u.Username)
+	})
+}
+
+func userAndRole(ctx context.Context, e *env, args []string) (models.User, string, error) {
+	if len(args) != 2 {
+		return models.User{}, "", errUsage
+	}
+	role := args[1]
+	if !slices.Contains(store.RoleNames, role) {
+		return models.User{}, "", fmt.Errorf("unknown role %s; roles are %s", role, strings.Join(store.RoleNames, ", "))
+	}
+	u, err := findUser(ctx, e, args[0])
+	return u, role, err
+}
+
+// ban is printed by the ban commands.
+type ban struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Banned   bool   `json:"banned"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+func usersBan(ctx context.Context, e *env, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	u, err := findUser(ctx, e, args[0])
+	if err != nil {
+		return err
+	}
+	reason := strings.Join(args[1:], " ")
+	if err := store.Ban(ctx, e.db, u.ID, reason); err != nil {
+		return err
+	}
+	return e.print(ban{u.ID, u.Username, true, reason}, func(w io.Writer) {
+		fmt.Fprintf(w, "Banned %s\n", u.Username)
+	})
+}
+
+func usersUnban(ctx context.Context, e *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	u, err := findUser(ctx, e, args[0])
+	if err != nil {
+		return err
+	}
+	if err := store.Unban(ctx, e.db, u.ID); err != nil {
+		return err
+	}
+	return e.print(ban{UserID: u.ID, Username: u.Username}, func(w io.Writer) {
+		fmt.Fprintf(w, "Unbanned %s\n", u.Username)
+	})
+}
+
+func usersDelete(ctx context.Context, e *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	u, err := findUser(ctx, e, args[0])
+	if err != nil {
+		return err
+	}
+	blobs, err := blob.Open(e.cfg.Blob)
+	if err != nil {
+		return err
+	}
+	if err := handlers.DeleteAccount(ctx, e.db, blobs, u.ID); err != nil {
+		return err
+	}
+	return e.print(map[string]any{"deleted": u.ID, "username": u.Username}, func(w io.Writer) {
+		fmt.Fprintf(w, "Deleted %s\n", u.Username)
+	})
+}
+
+// findUser looks a user up by ID or username.
+func findUser(ctx context.Context, e *env, ref string) (models.User, error) {
+	u, err := store.User(ctx, e.db, ref)
+	if errors.Is(err, store.ErrNotFound) {
+		return models.User{}, fmt.Errorf("no user %s", ref)
+	}
+	return u, err
+}
+
+// setDeleted builds the commands that soft-delete and restore posts and
+// comments.
+func setDeleted(kind string, set func(context.Context, store.Querier, int, bool) error, deleted bool) func(context.Context, *env, []string) error {
+	return func(ctx context.Context, e *env, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("%s ID must be a number, not %q", kind, args[0])
+		}
+		err = set(ctx, e.db, id, deleted)
+		if errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("no %s %d", kind, id)
+		} else if err != nil {
+			return err
+		}
+
+		verb := "Restored"
+		if deleted {
+			verb = "Deleted"
+		}
+		return e.print(map[string]any{kind: id, "deleted": deleted}, func(w io.Writer) {
+			fmt.Fprintf(w, "%s %s %d\n", verb, kind, id)
+		})
+	}
+}
+
+func keysList(ctx context.Context, e *env, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	keys := e.tokens.Keys()
+	return e.print(keys, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tCREATED\tSTATUS")
+		for _, k := range keys {
+			id, created := k.ID, formatTime(k.CreatedAt)
+			if id == "" {
+				id, created = "JWT_SECRET", "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", id, created, keyStatus(k.Signing, k.ActiveAt, k.RetiresAt))
+		}
+	})
+}
+
+func keyStatus(signing bool, activeAt, retiresAt time.Time) string {
+	switch {
+	case signing:
+		return "signing"
+	case time.Now().Before(activeAt):
+		return "signing from " + formatTime(activeAt)
+	case !retiresAt.IsZero():
+		return "verifying until " + formatTime(retiresAt)
+	default:
+		return "verifying"
+	}
+}
+
+func keysRotate(ctx context.Context, e *env, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	k, deleted, err := e.tokens.Rotate(ctx, e.db)
+	if err != nil {
+		return err
+	}
+
+	result := struct {
+		ID        string    `json:"id"`
+		CreatedAt time.Time `json:"created_at"`
+		Deleted   int64     `json:"deleted_keys"`
+	}{k.ID, k.CreatedAt, deleted}
+	return e.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Added key %s; API instances start signing with it within a couple of minutes\n", k.ID)
+		if deleted > 0 {
+			fmt.Fprintf(w, "Deleted %d retired keys\n", deleted)
+		}
+	})
+}
+
+func stats(ctx context.Context, e *env, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	s, err := store.CountAll(ctx, e.db)
+	if err != nil {
+		return err
+	}
+	return e.print(s, func(w io.Writer) {
+		fmt.Fprintf(w, "users\t%d\t(%d banned)\n", s.Users, s.BannedUsers)
+		fmt.Fprintf(w, "topics\t%d\n", s.Topics)
+		fmt.Fprintf(w, "posts\t%d\t(%d deleted, %d in the last 24h)\n", s.Posts, s.DeletedPosts, s.PostsToday)
+		fmt.Fprintf(w, "comments\t%d\t(%d deleted, %d in the last 24h)\n", s.Comments, s.DeletedComments, s.CommentsToday)
+		fmt.Fprintf(w, "votes\t%d\n", s.Votes)
+		fmt.Fprintf(w, "media\t%d\n", s.Media)
+	})
+}
+
+func seedData(ctx context.Context, e *env, args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	opts := seed.Options{}
+	fs.Uint64Var(&opts.Seed, "seed", 1, "random seed; the same seed and sizes give the same data")
+	fs.IntVar(&opts.Users, "users", 50, "number of users")
+	fs.IntVar(&opts.Topics, "topics", 8, "number of topics")
+	fs.IntVar(&opts.Posts, "posts", 500, "number of posts")
+	fs.IntVar(&opts.Comments, "comments", 3000, "number of comments")
+	fs.IntVar(&opts.Votes, "votes", 10000, "number of votes, at most one per user and post")
+	days := fs.Int("days", 90, "how many days of activity to spread posts over")
+	end := fs.String("end", time.Now().UTC().Format(time.DateOnly), "date the activity ends, as YYYY-MM-DD")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+
+	endDate, err := time.Parse(time.DateOnly, *end)
+	if err != nil {
+		return fmt.Errorf("-end must be a date like 2024-01-31, not %q", *end)
+	}
+	if opts.Users < 1 || opts.Topics < 1 || opts.Posts < 0 || opts.Comments < 0 || opts.Votes < 0 || *days < 1 {
+		return errors.New("-users, -topics and -days must be positive, and the other sizes not negative")
+	}
+	opts.End = endDate
+	opts.Start = endDate.AddDate(0, 0, -*days)
+
+	blobs, err := blob.Open(e.cfg.Blob)
+	if err != nil {
+		return err
+	}
+	d := seed.Generate(opts)
+	if err := seed.Insert(ctx, e.db, blobs, d); err != nil {
+		return err
+	}
+
+	counts := map[string]int{
+		"users":    len(d.Users),
+		"topics":   len(d.Topics),
+		"posts":    len(d.Posts),
+		"comments": len(d.Comments),
+		"votes":    len(d.Votes),
+	}
+	return e.print(counts, func(w io.Writer) {
+		fmt.Fprintf(w, "Created %d users, %d topics, %d posts, %d comments and %d votes\n",
+			len(d.Users), len(d.Topics), len(d.Posts), len(d.Comments), len(d.Votes))
+	})
+}
+
+func exportData(ctx context.Context, e *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	blobs, err := blob.Open(e.cfg.Blob)
+	if err != nil {
+		return err
+	}
+	m, err := archive.Export(ctx, e.db, blobs, args[0])
+	if err != nil {
+		return err
+	}
+	return e.print(m, func(w io.Writer) {
+		fmt.Fprintf(w, "Exported %s to %s\n", describeCounts(m.Counts), args[0])
+		if len(m.MissingImages) > 0 {
+			fmt.Fprintf(w, "Left out %d images missing from the blob store, listed in the manifest\n", len(m.MissingImages))
+		}
+	})
+}
+
+func importData(ctx context.Context, e *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	blobs, err := blob.Open(e.cfg.Blob)
+	if err != nil {
+		return err
+	}
+	m, err := archive.Import(ctx, e.db, blobs, args[0])
+	if err != nil {
+		return err
+	}
+	return e.print(m, func(w io.Writer) {
+		fmt.Fprintf(w, "Imported %s, exported at %s\n", describeCounts(m.Counts), formatTime(m.ExportedAt))
+	})
+}
+
+func describeCounts(c archive.Counts) string {
+	return fmt.Sprintf("%d users, %d topics, %d posts, %d uploads, %d comments, %d votes and %d images",
+		c.Users, c.Topics, c.Posts, c.Media, c.Comments, c.Votes, c.Images)
+}
+
+func formatTime(t time.Time) string {
+	return t.Local().Format(time.DateTime)
+}
+
+// invalid turns a validation problem into an error listing its messages,
+// which name their fields.
+func invalid(err error) error {
+	var p *problem.Error
+	if !errors.As(err, &p) || len(p.Fields) == 0 {
+		return err
+	}
+	msgs := make([]string, len(p.Fields))
+	for i, f := range p.Fields {
+		msgs[i] = f.Message
+	}
+	return errors.New(strings.Join(msgs, " "))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"backend/internal/handlers"
+	"backend/internal/store"
+)
+
+// missingID is the ID of a post or comment that usersDB does not have.
+const missingID = 404
+
+// usersDB stands in for Postgres, knowing a few users and recording the
+// arguments of each statement run against it.
+type usersDB struct {
+	users map[string]int64 // IDs by username
+	execs []string
+}
+
+func (u *usersDB) Connect(context.Context) (driver.Conn, error) { return u, nil }
+func (u *usersDB) Driver() driver.Driver                        { return nil }
+func (u *usersDB) Prepare(string) (driver.Stmt, error)          { return nil, errors.New("not supported") }
+func (u *usersDB) Close() error                                 { return nil }
+func (u *usersDB) Begin() (driver.Tx, error)                    { return nil, errors.New("not supported") }
+
+func (u *usersDB) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if !strings.HasPrefix(query, "-- name: User\n") {
+		return nil, errors.New("unexpected query: " + query)
+	}
+	r := &userRows{}
+	for name, id := range u.users {
+		if args[0].Value == id || args[0].Value == name {
+			r.values = []driver.Value{id, name, false, 0.0}
+		}
+	}
+	return r, nil
+}
+
+func (u *usersDB) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	values := make([]any, len(args))
+	for i, a := range args {
+		values[i] = a.Value
+	}
+	u.execs = append(u.execs, strings.TrimSpace(fmt.Sprintln(values...)))
+	if args[0].Value == int64(missingID) {
+		return driver.RowsAffected(0), nil
+	}
+	return driver.RowsAffected(1), nil
+}
+
+type userRows struct {
+	values []driver.Value // nil when no user matched
+}
+
+func (r *userRows) Columns() []string {
+	return []string{"id", "username", "has_image", "image_updated_at"}
+}
+func (r *userRows) Close() error { return nil }
+
+func (r *userRows) Next(dest []driver.Value) error {
+	if r.values == nil {
+		return io.EOF
+	}
+	copy(dest, r.values)
+	r.values = nil
+	return nil
+}
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantErr  string // errUsage's "usage" when the arguments are wrong
+		wantOut  string
+		wantExec string // arguments of the statement run, if any
+	}{
+		{args: []string{"roles", "grant", "alice", "moderator"},
+			wantOut: "Granted alice the moderator role\n", wantExec: "7 moderator"},
+		{args: []string{"roles", "grant", "7", "admin"},
+			wantOut: "Granted alice the admin role\n", wantExec: "7 admin"},
+		{args: []string{"roles", "grant", "alice"}, wantErr: "usage"},
+		{args: []string{"roles", "grant", "alice", "moderator", "admin"}, wantErr: "usage"},
+		{args: []string{"roles", "grant", "alice", "owner"}, wantErr: "unknown role owner; roles are admin, moderator"},
+		{args: []string{"roles", "grant", "bob", "admin"}, wantErr: "no user bob"},
+		{args: []string{"roles", "revoke", "alice", "admin"},
+			wantOut: "Revoked the admin role from alice\n", wantExec: "7 admin"},
+		{args: []string{"roles", "list", "alice"}, wantErr: "usage"},
+
+		{args: []string{"users", "ban", "alice"}, wantOut: "Banned alice\n", wantExec: "7"},
+		{args: []string{"users", "ban", "alice", "Posting", "ads"}, wantOut: "Banned alice\n", wantExec: "7 Posting ads"},
+		{args: []string{"users", "ban", "alice", "Posting ads"}, wantOut: "Banned alice\n", wantExec: "7 Posting ads"},
+		{args: []string{"users", "ban"}, wantErr: "usage"},
+		{args: []string{"users", "ban", "bob", "spam"}, wantErr: "no user bob"},
+		{args: []string{"users", "unban", "alice"}, wantOut: "Unbanned alice\n", wantExec: "7"},
+		{args: []string{"users", "unban", "alice", "now"}, wantErr: "usage"},
+		{args: []string{"users", "delete"}, wantErr: "usage"},
+		{args: []string{"users", "delete", "alice", "bob"}, wantErr: "usage"},
+		{args: []string{"users", "delete", "bob"}, wantErr: "no user bob"},
+
+		{args: []string{"posts", "delete", "12"}, wantOut: "Deleted post 12\n", wantExec: "12 true"},
+		{args: []string{"posts", "restore", "12"}, wantOut: "Restored post 12\n", wantExec: "12 false"},
+		{args: []string{"comments", "delete", "3"}, wantOut: "Deleted comment 3\n", wantExec: "3 true"},
+		{args: []string{"posts", "delete", "twelve"}, wantErr: `post ID must be a number, not "twelve"`},
+		{args: []string{"comments", "restore", fmt.Sprint(missingID)}, wantErr: "no comment 404", wantExec: "404 false"},
+		{args: []string{"posts", "delete"}, wantErr: "usage"},
+		{args: []string{"posts", "delete", "1", "2"}, wantErr: "usage"},
+
+		{args: []string{"topics", "create", ""}, wantErr: "Name is required."},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			cmd, args, ok := findCommand(tt.args)
+			if !ok {
+				t.Fatalf("no command for %q", tt.args)
+			}
+			users := &usersDB{users: map[string]int64{"alice": 7}}
+			var out bytes.Buffer
+			e := &env{db: sql.OpenDB(users), out: &out}
+
+			err := cmd.run(context.Background(), e, args)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.wantOut {
+				t.Errorf("output = %q, want %q", out.String(), tt.wantOut)
+			}
+			var exec string
+			if len(users.execs) > 0 {
+				exec = users.execs[0]
+			}
+			if len(users.execs) > 1 || exec != tt.wantExec {
+				t.Errorf("statements run with %q, want %q", users.execs, tt.wantExec)
+			}
+		})
+	}
+}
+
+func TestFindCommand(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantName string // "" when no command matches
+		wantArgs []string
+	}{
+		{[]string{"stats"}, "stats", []string{}},
+		{[]string{"users", "ban", "alice", "spam"}, "users ban", []string{"alice", "spam"}},
+		{[]string{"users", "unban", "alice"}, "users unban", []string{"alice"}},
+		{[]string{"users"}, "", nil},
+		{[]string{"users", "bann", "alice"}, "", nil},
+		{[]string{"ban", "users", "alice"}, "", nil},
+		{nil, "", nil},
+	}
+	for _, tt := range tests {
+		cmd, args, ok := findCommand(tt.args)
+		if ok != (tt.wantName != "") || cmd.name != tt.wantName || fmt.Sprint(args) != fmt.Sprint(tt.wantArgs) {
+			t.Errorf("findCommand(%q) = %q, %q, %v, want %q, %q", tt.args, cmd.name, args, ok, tt.wantName, tt.wantArgs)
+		}
+	}
+}
+
+// Validation messages already name their fields, so they are not named
+// again.
+func TestInvalid(t *testing.T) {
+	_, _, err := handlers.ValidateTopic("", strings.Repeat("x", 1001))
+	want := "Name is required. Description must be at most 1000 characters."
+	if got := invalid(err); got == nil || got.Error() != want {
+		t.Errorf("invalid = %v, want %q", got, want)
+	}
+	if err := invalid(store.ErrNotFound); err != store.ErrNotFound {
+		t.Errorf("invalid changed an error that is not a validation problem: %v", err)
+	}
+}
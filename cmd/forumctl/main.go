@@ -0,0 +1,147 @@
+// Command forumctl operates the forum from the command line: it manages
+// topics, roles, bans, accounts and deleted content, rotates the keys that
+// sign access tokens, prints statistics, and seeds, exports and imports
+// whole databases. It reads the same configuration as the API and works on
+// its database directly.
+//
+// Usage:
+//
+//	forumctl [-json] [-config file] <command> [arguments]
+//
+// Run forumctl without arguments to list the commands.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+
+	"backend/internal/auth"
+	"backend/internal/config"
+	"backend/internal/db"
+	"backend/internal/logging"
+
+	"github.com/joho/godotenv"
+)
+
+// env is what commands work with.
+type env struct {
+	cfg    *config.Config
+	db     *sql.DB
+	tokens *auth.Tokens
+	json   bool
+	out    io.Writer
+}
+
+// print writes v as JSON with -json, and otherwise calls text with a
+// writer whose tab-separated columns are aligned.
+func (e *env) print(v any, text func(w io.Writer)) error {
+	if e.json {
+		enc := json.NewEncoder(e.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(e.out, 0, 4, 2, ' ', 0)
+	text(tw)
+	return tw.Flush()
+}
+
+// errUsage marks errors caused by how forumctl was called.
+var errUsage = errors.New("usage")
+
+func main() {
+	godotenv.Load()
+
+	fs := flag.NewFlagSet("forumctl", flag.ContinueOnError)
+	fs.Usage = func() { usage(fs.Output()) }
+	asJSON := fs.Bool("json", false, "print results as JSON")
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON file with settings, as read by the API ($CONFIG_FILE)")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+
+	cmd, args, ok := findCommand(fs.Args())
+	if !ok {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	var configArgs []string
+	if *configFile != "" {
+		configArgs = []string{"-config", *configFile}
+	}
+	cfg, err := config.Load(configArgs)
+	if err != nil {
+		fail(err)
+	}
+
+	// Logs go to stderr, so they never mix with output meant for scripts.
+	logger, err := logging.New(os.Stderr, "text", cfg.Log.Level)
+	if err != nil {
+		fail(err)
+	}
+	slog.SetDefault(logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := db.Connect(ctx, cfg.Database); err != nil {
+		fail(fmt.Errorf("connecting to the database: %w", err))
+	}
+	defer db.Conn.Close()
+	if err := db.Migrate(db.Conn); err != nil {
+		fail(fmt.Errorf("migrating the database: %w", err))
+	}
+
+	tokens := auth.New(cfg.Auth.Secret, cfg.Auth.TokenTTL)
+	if err := tokens.Reload(ctx, db.Conn); errors.Is(err, auth.ErrUnreadableKey) {
+		fmt.Fprintln(os.Stderr, "forumctl: skipped signing keys:", err)
+	} else if err != nil {
+		fail(fmt.Errorf("loading signing keys: %w", err))
+	}
+
+	e := &env{cfg: cfg, db: db.Conn, tokens: tokens, json: *asJSON, out: os.Stdout}
+	if err := cmd.run(ctx, e, args); errors.Is(err, errUsage) {
+		fmt.Fprintf(os.Stderr, "usage: forumctl %s %s\n", cmd.name, cmd.args)
+		os.Exit(2)
+	} else if err != nil {
+		fail(err)
+	}
+}
+
+// findCommand picks the command named by the leading words of args and
+// returns it with the arguments that follow.
+func findCommand(args []string) (command, []string, bool) {
+	for _, c := range commands {
+		words := strings.Fields(c.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == c.name {
+			return c, args[len(words):], true
+		}
+	}
+	return command{}, nil, false
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: forumctl [-json] [-config file] <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.name, c.args, c.help)
+	}
+	tw.Flush()
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "forumctl:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,174 @@
+// Command migrateimages copies images stored as bytea in Postgres into the
+// configured blob store and clears the bytea columns. Images are sanitized
+// on the way, as uploads are; those that fail are left in place and
+// logged. It is safe to run repeatedly, and while the API is serving
+// traffic.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"backend/internal/blob"
+	"backend/internal/config"
+	"backend/internal/db"
+	"backend/internal/images"
+	"backend/internal/logging"
+
+	"github.com/joho/godotenv"
+)
+
+// table describes one kind of row that may still hold a bytea image.
+type table struct {
+	name   string
+	id     string
+	idType string // of the id column, which rows are looked up by
+	data   string
+	key    string
+	prefix func(id string, uploader int) string
+}
+
+var tables = []table{
+	{
+		name:   "users",
+		id:     "id",
+		idType: "int",
+		data:   "image",
+		key:    "image_key",
+		prefix: func(id string, _ int) string { return "users/" + id },
+	},
+	{
+		name:   "topics",
+		id:     "name",
+		idType: "text",
+		data:   "image",
+		key:    "image_key",
+		prefix: func(id string, _ int) string { return "topics/" + id },
+	},
+	{
+		name:   "media",
+		id:     "id",
+		idType: "int",
+		data:   "data",
+		key:    "blob_key",
+		prefix: func(_ string, uploader int) string { return "media/" + strconv.Itoa(uploader) },
+	},
+}
+
+func main() {
+	godotenv.Load()
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+	slog.SetDefault(logger)
+
+	if err := db.Connect(context.Background(), cfg.Database); err != nil {
+		fatal("Failed to connect to the database", err)
+	}
+	if err := db.Migrate(db.Conn); err != nil {
+		fatal("Failed to migrate the database", err)
+	}
+
+	blobs, err := blob.Open(cfg.Blob)
+	if err != nil {
+		fatal("Failed to open the blob store", err)
+	}
+
+	for _, t := range tables {
+		n, err := migrate(context.Background(), db.Conn, blobs, t)
+		if err != nil {
+			fatal("Failed to migrate images of "+t.name, err)
+		}
+		slog.Info("Moved images to the blob store", "table", t.name, "count", n)
+	}
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+// migrate moves the images of one table one row at a time, so memory use
+// stays bounded by the largest single image. IDs are read as text, and
+// cast back to the column's type when looking rows up, so the lookups can
+// use the primary key.
+func migrate(ctx context.Context, conn *sql.DB, blobs blob.Store, t table) (int, error) {
+	uploader := "0"
+	if t.name == "media" {
+		uploader = "uploader"
+	}
+
+	rows, err := conn.QueryContext(ctx,
+		`SELECT `+t.id+`::text, `+uploader+` FROM `+t.name+` WHERE `+t.data+` IS NOT NULL`,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	type pending struct {
+		id       string
+		uploader int
+	}
+	var todo []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.uploader); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		todo = append(todo, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, p := range todo {
+		var data []byte
+		err := conn.QueryRowContext(ctx,
+			`SELECT `+t.data+` FROM `+t.name+` WHERE `+t.id+` = $1::`+t.idType,
+			p.id,
+		).Scan(&data)
+		if err == sql.ErrNoRows || data == nil {
+			continue
+		} else if err != nil {
+			return moved, err
+		}
+
+		clean, err := images.Sanitize(data)
+		if err != nil {
+			slog.Warn("Skipped an image that failed sanitizing", "table", t.name, "id", p.id, "err", err)
+			continue
+		}
+		key := blob.Key(t.prefix(p.id, p.uploader), clean)
+		if err := blobs.Put(ctx, key, clean); err != nil {
+			return moved, err
+		}
+
+		// Only clear the bytea if it was not replaced while we were copying.
+		_, err = conn.ExecContext(ctx,
+			`UPDATE `+t.name+` SET `+t.key+` = $1, `+t.data+` = NULL
+			WHERE `+t.id+` = $2::`+t.idType+` AND `+t.data+` = $3`,
+			key,
+			p.id,
+			data,
+		)
+		if err != nil {
+			return moved, err
+		}
+		moved++
+	}
+
+	return moved, nil
+}
@@ -0,0 +1,145 @@
+// Package archive exports the whole forum to a portable directory and
+// imports it into an empty database, keeping IDs, reply threads and
+// timestamps. An archive holds manifest.json, one newline-delimited JSON
+// file per kind of record and the images as separate files, so it can be
+// read and processed without this code:
+//
+//	manifest.json
+//	users.ndjson
+//	topics.ndjson
+//	posts.ndjson
+//	media.ndjson
+//	comments.ndjson
+//	votes.ndjson
+//	images/<sha256>.png
+//
+// Users, posts, uploads and comments keep their IDs. Topics have no
+// surrogate ID: their name is their primary key, which posts refer to, so
+// they are exported and imported by name.
+//
+// Records name their image by its path within the archive. Images are
+// content-addressed, so one used in several places is stored once.
+//
+// ExportUser writes the same layout, limited to one user's own data, as a
+// zip file for users who ask for a copy of what the forum holds on them.
+package archive
+
+import (
+	"errors"
+	"time"
+)
+
+// Format and Version identify the archive layout in manifest.json. Version
+// changes whenever a change to the layout would break older importers.
+// PersonalFormat marks the archives of a single user's data.
+const (
+	Format         = "forum-archive"
+	PersonalFormat = "forum-personal-data"
+	Version        = 1
+)
+
+const manifestFile = "manifest.json"
+
+var (
+	// ErrNotEmpty is returned by Import when the database already has users
+	// or topics, and by Export when the target directory has files in it.
+	ErrNotEmpty = errors.New("not empty")
+	// ErrUnsupported is returned by Import for archives it cannot read.
+	ErrUnsupported = errors.New("unsupported archive")
+)
+
+// Manifest describes an archive. It is written last, so an export that
+// failed halfway cannot be imported.
+type Manifest struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	Counts     Counts    `json:"counts"`
+	// MissingImages are the blob keys of images that records referred to
+	// but the blob store no longer had. Those records were exported
+	// without their image.
+	MissingImages []string `json:"missing_images,omitempty"`
+}
+
+// Counts are the records in each file, which Import checks to catch
+// truncated archives.
+type Counts struct {
+	Users    int `json:"users"`
+	Topics   int `json:"topics"`
+	Posts    int `json:"posts"`
+	Media    int `json:"media"`
+	Comments int `json:"comments"`
+	Votes    int `json:"votes"`
+	Images   int `json:"images"`
+}
+
+type User struct {
+	ID             int       `json:"id"`
+	Username       string    `json:"username"`
+	Image          string    `json:"image,omitempty"`
+	ImageUpdatedAt time.Time `json:"image_updated_at"`
+	Roles          []Role    `json:"roles,omitempty"`
+	BannedAt       time.Time `json:"banned_at,omitzero"`
+	BanReason      string    `json:"ban_reason,omitempty"`
+	// DeletionRequestedAt is set while the account waits to be deleted.
+	DeletionRequestedAt time.Time `json:"deletion_requested_at,omitzero"`
+}
+
+type Role struct {
+	Role      string    `json:"role"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+// Topic has no ID field, as topics have none in the database. Name is the
+// key, which Post.Topic refers to.
+type Topic struct {
+	Name           string    `json:"name"`
+	Description    string    `json:"description"`
+	Image          string    `json:"image,omitempty"`
+	ImageUpdatedAt time.Time `json:"image_updated_at"`
+	Version        int       `json:"version"`
+}
+
+type Post struct {
+	ID        int       `json:"id"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	Topic     string    `json:"topic"`
+	Creator   int       `json:"creator"`
+	CreatedAt time.Time `json:"created_at"`
+	IsEdited  bool      `json:"is_edited"`
+	Version   int       `json:"version"`
+	DeletedAt time.Time `json:"deleted_at,omitzero"`
+}
+
+// Media are images uploaded for posts. Post is 0 for uploads not attached
+// to any post yet.
+type Media struct {
+	ID        int       `json:"id"`
+	Uploader  int       `json:"uploader"`
+	Post      int       `json:"post,omitempty"`
+	Alt       string    `json:"alt"`
+	Position  int       `json:"position"`
+	CreatedAt time.Time `json:"created_at"`
+	Image     string    `json:"image"`
+}
+
+// Comments are exported in ID order, which puts every reply after the
+// comment it answers. Parent is 0 for top-level comments.
+type Comment struct {
+	ID        int       `json:"id"`
+	Body      string    `json:"body"`
+	Post      int       `json:"post"`
+	Creator   int       `json:"creator"`
+	Parent    int       `json:"parent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	IsEdited  bool      `json:"is_edited"`
+	Version   int       `json:"version"`
+	DeletedAt time.Time `json:"deleted_at,omitzero"`
+}
+
+type Vote struct {
+	Post       int  `json:"post"`
+	User       int  `json:"user"`
+	IsPositive bool `json:"is_positive"`
+}
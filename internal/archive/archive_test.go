@@ -0,0 +1,192 @@
+package archive
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"backend/internal/blob"
+	"backend/internal/store"
+)
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string // "" for no manifest.json
+		wantErr  string // "" when the manifest is accepted
+	}{
+		{"current", `{"format": "forum-archive", "version": 1, "counts": {"users": 2}}`, ""},
+		{"no manifest", "", "has no manifest.json"},
+		{"malformed", `{"format": "forum-archive",`, "manifest.json: unexpected end of JSON input"},
+		{"other format", `{"format": "something-else", "version": 1}`, `format "something-else", expected "forum-archive"`},
+		{"personal data", `{"format": "forum-personal-data", "version": 1}`, `format "forum-personal-data"`},
+		{"no version", `{"format": "forum-archive"}`, "version 0"},
+		{"newer version", fmt.Sprintf(`{"format": "forum-archive", "version": %d}`, Version+1), fmt.Sprintf("version %d, this build reads up to version %d", Version+1, Version)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.manifest != "" {
+				writeFile(t, dir, manifestFile, tt.manifest)
+			}
+			m, err := readManifest(dir)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("readManifest: %v", err)
+				}
+				if m.Counts.Users != 2 {
+					t.Errorf("Counts.Users = %d, want 2", m.Counts.Users)
+				}
+				return
+			}
+			if !errors.Is(err, ErrUnsupported) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("readManifest error = %v, want ErrUnsupported containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRead(t *testing.T) {
+	votes := func(n int) string {
+		var b strings.Builder
+		enc := json.NewEncoder(&b)
+		for i := range n {
+			enc.Encode(Vote{Post: i + 1, User: 1, IsPositive: i%2 == 0})
+		}
+		return b.String()
+	}
+	tests := []struct {
+		name        string
+		content     string
+		wantN       int
+		wantBatches []int // sizes of the batches passed to load
+		wantErr     string
+	}{
+		{name: "empty", content: "", wantN: 0},
+		{name: "one batch", content: votes(3), wantN: 3, wantBatches: []int{3}},
+		{name: "full batch", content: votes(store.BatchSize), wantN: store.BatchSize, wantBatches: []int{store.BatchSize}},
+		{name: "several batches", content: votes(2*store.BatchSize + 1), wantN: 2*store.BatchSize + 1,
+			wantBatches: []int{store.BatchSize, store.BatchSize, 1}},
+		{name: "malformed record", content: votes(2) + `{"post": "three"}` + "\n" + votes(1), wantN: 2,
+			wantErr: "votes.ndjson: record 3: json: cannot unmarshal string"},
+		{name: "truncated record", content: votes(1) + `{"post": 2, `, wantN: 1,
+			wantErr: "votes.ndjson: record 2: unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, dir, "votes.ndjson", tt.content)
+
+			var batches []int
+			next := 1
+			n, err := read(dir, "votes.ndjson", func(batch []Vote) error {
+				batches = append(batches, len(batch))
+				for _, v := range batch {
+					if v.Post != next {
+						return fmt.Errorf("got post %d, want %d", v.Post, next)
+					}
+					next++
+				}
+				return nil
+			})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("read error = %v, want one containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if n != tt.wantN {
+				t.Errorf("read %d records, want %d", n, tt.wantN)
+			}
+			if tt.wantErr == "" && !slices.Equal(batches, tt.wantBatches) {
+				t.Errorf("batches = %v, want %v", batches, tt.wantBatches)
+			}
+		})
+	}
+
+	t.Run("load fails", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, dir, "votes.ndjson", votes(1))
+		failed := errors.New("duplicate vote")
+		if _, err := read(dir, "votes.ndjson", func([]Vote) error { return failed }); !errors.Is(err, failed) {
+			t.Fatalf("read error = %v, want %v", err, failed)
+		}
+	})
+}
+
+// Images leave through the exporter and come back through the importer
+// unchanged, and one missing from the blob store does not fail the export.
+func TestImageRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, imagesDir), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("GIF89a not quite an image")
+	from := blob.NewMemory()
+	if err := from.Put(ctx, "users/abc.gif", data); err != nil {
+		t.Fatal(err)
+	}
+	x := &exporter{
+		ctx:    ctx,
+		blobs:  from,
+		images: map[string]bool{},
+		create: func(name string) (io.WriteCloser, error) {
+			return os.Create(filepath.Join(dir, filepath.FromSlash(name)))
+		},
+	}
+
+	name, err := x.image(sql.NullString{String: "users/abc.gif", Valid: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := x.image(sql.NullString{String: "users/abc.gif", Valid: true}, nil); err != nil || again != name {
+		t.Errorf("exporting the image again = %q, %v, want %q", again, err, name)
+	}
+	missing, err := x.image(sql.NullString{String: "users/gone.png", Valid: true}, nil)
+	if err != nil || missing != "" {
+		t.Errorf("exporting a missing image = %q, %v, want it left out", missing, err)
+	}
+	if !slices.Equal(x.missing, []string{"users/gone.png"}) {
+		t.Errorf("missing = %q, want the missing image", x.missing)
+	}
+	if none, err := x.image(sql.NullString{}, nil); err != nil || none != "" {
+		t.Errorf("exporting no image = %q, %v, want \"\"", none, err)
+	}
+	if len(x.images) != 1 {
+		t.Errorf("exported %d images, want 1", len(x.images))
+	}
+
+	to := blob.NewMemory()
+	im := &importer{ctx: ctx, blobs: to, dir: dir, images: map[string]bool{}}
+	key, err := im.image(name, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := to.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("imported image = %q, want %q", got, data)
+	}
+	if _, err := im.image("../manifest.json", "users"); err == nil {
+		t.Error("imported a file outside the images directory")
+	}
+}
@@ -0,0 +1,393 @@
+package archive
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"backend/internal/blob"
+	"backend/internal/images"
+	"backend/internal/logging"
+)
+
+const imagesDir = "images"
+
+// Export writes every user, topic, post, upload, comment and vote to dir,
+// which must be empty or not exist yet, along with the images they use.
+// It reads one snapshot of the database, so the archive is consistent even
+// while the forum is in use.
+func Export(ctx context.Context, db *sql.DB, blobs blob.Store, dir string) (Manifest, error) {
+	if err := emptyDir(dir); err != nil {
+		return Manifest{}, err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, imagesDir), 0o755); err != nil {
+		return Manifest{}, err
+	}
+	x := &exporter{
+		blobs: blobs,
+		create: func(name string) (io.WriteCloser, error) {
+			return os.Create(filepath.Join(dir, filepath.FromSlash(name)))
+		},
+	}
+	return export(ctx, db, x, Format)
+}
+
+// ExportUser writes the personal data of one user to w as a zip file: their
+// profile and image, and the posts, uploads, comments and votes they made,
+// laid out as in a full archive. Its format is PersonalFormat, which Import
+// refuses.
+func ExportUser(ctx context.Context, db *sql.DB, blobs blob.Store, userID int, w io.Writer) (Manifest, error) {
+	zw := zip.NewWriter(w)
+	now := time.Now()
+	x := &exporter{
+		blobs: blobs,
+		user:  userID,
+		create: func(name string) (io.WriteCloser, error) {
+			return &zipFile{zw: zw, header: zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now}}, nil
+		},
+	}
+	m, err := export(ctx, db, x, PersonalFormat)
+	if err != nil {
+		return Manifest{}, err
+	}
+	return m, zw.Close()
+}
+
+func export(ctx context.Context, db *sql.DB, x *exporter, format string) (Manifest, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer tx.Rollback()
+
+	x.ctx, x.tx, x.images = ctx, tx, map[string]bool{}
+	m := Manifest{Format: format, Version: Version, ExportedAt: time.Now().UTC()}
+	if m.Counts.Users, err = x.users(); err != nil {
+		return Manifest{}, err
+	}
+	if x.user == 0 {
+		if m.Counts.Topics, err = x.topics(); err != nil {
+			return Manifest{}, err
+		}
+	}
+	if m.Counts.Posts, err = x.posts(); err != nil {
+		return Manifest{}, err
+	}
+	if m.Counts.Media, err = x.media(); err != nil {
+		return Manifest{}, err
+	}
+	if m.Counts.Comments, err = x.comments(); err != nil {
+		return Manifest{}, err
+	}
+	if m.Counts.Votes, err = x.votes(); err != nil {
+		return Manifest{}, err
+	}
+	m.Counts.Images = len(x.images)
+	m.MissingImages = x.missing
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return Manifest{}, err
+	}
+	f, err := x.create(manifestFile)
+	if err != nil {
+		return Manifest{}, err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return Manifest{}, err
+	}
+	return m, f.Close()
+}
+
+// zipFile buffers an entry of a zip file until it is closed. Entries are
+// written one at a time, and export writes images while it is still
+// writing the records that use them.
+type zipFile struct {
+	bytes.Buffer
+	zw     *zip.Writer
+	header zip.FileHeader
+}
+
+func (f *zipFile) Close() error {
+	w, err := f.zw.CreateHeader(&f.header)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteTo(w)
+	return err
+}
+
+func emptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("directory %s is %w", dir, ErrNotEmpty)
+	}
+	return nil
+}
+
+type exporter struct {
+	ctx     context.Context
+	tx      *sql.Tx
+	blobs   blob.Store
+	create  func(name string) (io.WriteCloser, error)
+	user    int             // the only user whose data is written, or 0 for all
+	images  map[string]bool // paths written so far
+	missing []string        // keys of images the blob store did not have
+}
+
+// where limits a query to the rows of x.user, found by column, for ExportUser.
+func (x *exporter) where(column string) string {
+	if x.user == 0 {
+		return ""
+	}
+	return " WHERE " + column + " = $1"
+}
+
+func (x *exporter) args() []any {
+	if x.user == 0 {
+		return nil
+	}
+	return []any{x.user}
+}
+
+func (x *exporter) users() (int, error) {
+	roles := map[int][]Role{}
+	rows, err := x.tx.QueryContext(x.ctx,
+		`SELECT user_id, role, granted_at FROM user_roles`+x.where("user_id")+` ORDER BY user_id, role`,
+		x.args()...,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID int
+		var r Role
+		if err := rows.Scan(&userID, &r.Role, &r.GrantedAt); err != nil {
+			return 0, err
+		}
+		r.GrantedAt = r.GrantedAt.UTC()
+		roles[userID] = append(roles[userID], r)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return write(x, "users.ndjson",
+		`SELECT id, username, image, image_key, image_updated_at, banned_at, ban_reason, deletion_requested_at
+		FROM users`+x.where("id")+` ORDER BY id`,
+		func(rows *sql.Rows) (User, error) {
+			var u User
+			var legacy []byte
+			var key sql.NullString
+			var bannedAt, deletionRequestedAt sql.NullTime
+			err := rows.Scan(&u.ID, &u.Username, &legacy, &key, &u.ImageUpdatedAt, &bannedAt, &u.BanReason, &deletionRequestedAt)
+			if err != nil {
+				return u, err
+			}
+			u.ImageUpdatedAt = u.ImageUpdatedAt.UTC()
+			u.BannedAt = bannedAt.Time.UTC()
+			u.DeletionRequestedAt = deletionRequestedAt.Time.UTC()
+			u.Roles = roles[u.ID]
+			u.Image, err = x.image(key, legacy)
+			return u, err
+		})
+}
+
+func (x *exporter) topics() (int, error) {
+	return write(x, "topics.ndjson",
+		`SELECT name, description, image, image_key, image_updated_at, version FROM topics ORDER BY name`,
+		func(rows *sql.Rows) (Topic, error) {
+			var t Topic
+			var legacy []byte
+			var key sql.NullString
+			if err := rows.Scan(&t.Name, &t.Description, &legacy, &key, &t.ImageUpdatedAt, &t.Version); err != nil {
+				return t, err
+			}
+			t.ImageUpdatedAt = t.ImageUpdatedAt.UTC()
+			var err error
+			t.Image, err = x.image(key, legacy)
+			return t, err
+		})
+}
+
+func (x *exporter) posts() (int, error) {
+	return write(x, "posts.ndjson",
+		`SELECT id, title, body, topic, creator, created_at, is_edited, version, deleted_at
+		FROM posts`+x.where("creator")+` ORDER BY id`,
+		func(rows *sql.Rows) (Post, error) {
+			var p Post
+			var deletedAt sql.NullTime
+			err := rows.Scan(&p.ID, &p.Title, &p.Body, &p.Topic, &p.Creator, &p.CreatedAt, &p.IsEdited, &p.Version, &deletedAt)
+			p.CreatedAt = p.CreatedAt.UTC()
+			p.DeletedAt = deletedAt.Time.UTC()
+			return p, err
+		})
+}
+
+func (x *exporter) media() (int, error) {
+	return write(x, "media.ndjson",
+		`SELECT id, uploader, COALESCE(post, 0), alt, position, created_at, data, blob_key
+		FROM media`+x.where("uploader")+` ORDER BY id`,
+		func(rows *sql.Rows) (Media, error) {
+			var m Media
+			var legacy []byte
+			var key sql.NullString
+			if err := rows.Scan(&m.ID, &m.Uploader, &m.Post, &m.Alt, &m.Position, &m.CreatedAt, &legacy, &key); err != nil {
+				return m, err
+			}
+			m.CreatedAt = m.CreatedAt.UTC()
+			var err error
+			m.Image, err = x.image(key, legacy)
+			return m, err
+		})
+}
+
+func (x *exporter) comments() (int, error) {
+	return write(x, "comments.ndjson",
+		`SELECT id, body, post, creator, COALESCE(parent, 0), created_at, is_edited, version, deleted_at
+		FROM comments`+x.where("creator")+` ORDER BY id`,
+		func(rows *sql.Rows) (Comment, error) {
+			var c Comment
+			var deletedAt sql.NullTime
+			err := rows.Scan(&c.ID, &c.Body, &c.Post, &c.Creator, &c.Parent, &c.CreatedAt, &c.IsEdited, &c.Version, &deletedAt)
+			c.CreatedAt = c.CreatedAt.UTC()
+			c.DeletedAt = deletedAt.Time.UTC()
+			return c, err
+		})
+}
+
+func (x *exporter) votes() (int, error) {
+	return write(x, "votes.ndjson",
+		`SELECT post_id, user_id, is_positive FROM post_votes`+x.where("user_id")+` ORDER BY post_id, user_id`,
+		func(rows *sql.Rows) (Vote, error) {
+			var v Vote
+			err := rows.Scan(&v.Post, &v.User, &v.IsPositive)
+			return v, err
+		})
+}
+
+// write runs query and writes every row, as scan turns it into a record,
+// to one line of file. It returns the number of records written.
+func write[T any](x *exporter, file, query string, scan func(*sql.Rows) (T, error)) (int, error) {
+	f, err := x.create(file)
+	if err != nil {
+		return 0, err
+	}
+	n, err := writeRows(x, f, query, scan)
+	if err != nil {
+		f.Close()
+		return n, err
+	}
+	return n, f.Close()
+}
+
+func writeRows[T any](x *exporter, f io.Writer, query string, scan func(*sql.Rows) (T, error)) (int, error) {
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+
+	rows, err := x.tx.QueryContext(x.ctx, query, x.args()...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		r, err := scan(rows)
+		if err != nil {
+			return n, err
+		}
+		if err := enc.Encode(r); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	return n, w.Flush()
+}
+
+// image copies the image stored under key, or held in a legacy bytea
+// column, into the archive and returns its path there, or "" when the row
+// has no image. Images missing from the blob store are left out, and
+// listed in the manifest, rather than failing the whole export.
+func (x *exporter) image(key sql.NullString, legacy []byte) (string, error) {
+	var data []byte
+	switch {
+	case key.Valid:
+		var err error
+		data, err = x.blobs.Get(x.ctx, key.String)
+		if errors.Is(err, blob.ErrNotFound) {
+			logging.FromContext(x.ctx).Warn("Left a missing image out of the export", "key", key.String)
+			x.missing = append(x.missing, key.String)
+			return "", nil
+		} else if err != nil {
+			return "", fmt.Errorf("reading image %s: %w", key.String, err)
+		}
+	case legacy != nil:
+		// Legacy images predate upload checks. Those that fail them are
+		// left out, as the API does not serve them either.
+		var err error
+		if data, err = images.Sanitize(legacy); err != nil {
+			return "", nil
+		}
+	default:
+		return "", nil
+	}
+
+	sum := sha256.Sum256(data)
+	name := path.Join(imagesDir, hex.EncodeToString(sum[:])+extension(data))
+	if !x.images[name] {
+		f, err := x.create(name)
+		if err != nil {
+			return "", err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return "", err
+		}
+		if err := f.Close(); err != nil {
+			return "", err
+		}
+		x.images[name] = true
+	}
+	return name, nil
+}
+
+// extension names the image format for people browsing the archive.
+// Import does not rely on it.
+func extension(data []byte) string {
+	switch http.DetectContentType(data) {
+	case "image/png":
+		return ".png"
+	case "image/jpeg":
+		return ".jpg"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	}
+	return ""
+}
@@ -0,0 +1,350 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"backend/internal/blob"
+	"backend/internal/markdown"
+	"backend/internal/store"
+
+	"github.com/lib/pq"
+)
+
+// Import loads the archive in dir into an empty database in one
+// transaction, keeping its IDs and timestamps, and stores its images in
+// blobs. It returns the manifest of the archive.
+func Import(ctx context.Context, db *sql.DB, blobs blob.Store, dir string) (Manifest, error) {
+	m, err := readManifest(dir)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer tx.Rollback()
+
+	if used, err := store.HasForumData(ctx, tx); err != nil {
+		return Manifest{}, err
+	} else if used {
+		return Manifest{}, fmt.Errorf("the database is %w; import into an empty one", ErrNotEmpty)
+	}
+
+	im := &importer{ctx: ctx, tx: tx, blobs: blobs, dir: dir, images: map[string]bool{}}
+	var got Counts
+	if got.Users, err = read(dir, "users.ndjson", im.users); err != nil {
+		return Manifest{}, err
+	}
+	if got.Topics, err = read(dir, "topics.ndjson", im.topics); err != nil {
+		return Manifest{}, err
+	}
+	if got.Posts, err = read(dir, "posts.ndjson", im.posts); err != nil {
+		return Manifest{}, err
+	}
+	if got.Media, err = read(dir, "media.ndjson", im.media); err != nil {
+		return Manifest{}, err
+	}
+	if got.Comments, err = read(dir, "comments.ndjson", im.comments); err != nil {
+		return Manifest{}, err
+	}
+	if got.Votes, err = read(dir, "votes.ndjson", im.votes); err != nil {
+		return Manifest{}, err
+	}
+	got.Images = len(im.images)
+	if got != m.Counts {
+		return Manifest{}, fmt.Errorf("the archive holds %+v, but its manifest lists %+v; it may be incomplete", got, m.Counts)
+	}
+
+	if err := store.ResetSequences(ctx, tx); err != nil {
+		return Manifest{}, err
+	}
+	return m, tx.Commit()
+}
+
+func readManifest(dir string) (Manifest, error) {
+	var m Manifest
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return m, fmt.Errorf("%w: %s has no %s", ErrUnsupported, dir, manifestFile)
+	} else if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("%w: %s: %v", ErrUnsupported, manifestFile, err)
+	}
+	if m.Format != Format {
+		return m, fmt.Errorf("%w: format %q, expected %q", ErrUnsupported, m.Format, Format)
+	}
+	if m.Version < 1 || m.Version > Version {
+		return m, fmt.Errorf("%w: version %d, this build reads up to version %d", ErrUnsupported, m.Version, Version)
+	}
+	return m, nil
+}
+
+// read decodes the records in file and passes them to load in batches. It
+// returns the number of records read.
+func read[T any](dir, file string, load func([]T) error) (int, error) {
+	f, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+
+	var batch []T
+	n := 0
+	for {
+		var r T
+		if err := dec.Decode(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			return n, fmt.Errorf("%s: record %d: %w", file, n+1, err)
+		}
+		batch = append(batch, r)
+		n++
+		if len(batch) == store.BatchSize {
+			if err := load(batch); err != nil {
+				return n, fmt.Errorf("%s: %w", file, err)
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if err := load(batch); err != nil {
+			return n, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return n, nil
+}
+
+type importer struct {
+	ctx    context.Context
+	tx     *sql.Tx
+	blobs  blob.Store
+	dir    string
+	images map[string]bool // paths stored so far
+}
+
+// image stores the archive file at name in the blob store under prefix and
+// returns its key, or "" for records without an image.
+func (im *importer) image(name, prefix string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	if path.Dir(name) != imagesDir || !filepath.IsLocal(name) {
+		return "", fmt.Errorf("image %q is outside the %s directory", name, imagesDir)
+	}
+	data, err := os.ReadFile(filepath.Join(im.dir, filepath.FromSlash(name)))
+	if err != nil {
+		return "", err
+	}
+	key := blob.Key(prefix, data)
+	if err := im.blobs.Put(im.ctx, key, data); err != nil {
+		return "", err
+	}
+	im.images[name] = true
+	return key, nil
+}
+
+// timestamp formats t for a timestamptz parameter, and the zero time as ""
+// for NULLIF to turn into NULL.
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func (im *importer) users(batch []User) error {
+	var (
+		ids, roleUsers                                []int64
+		names, keys, imageTimes, bannedAt, banReasons []string
+		deletionRequestedAt                           []string
+		roles, grantedAt                              []string
+	)
+	for _, u := range batch {
+		key, err := im.image(u.Image, "users/"+strconv.Itoa(u.ID))
+		if err != nil {
+			return err
+		}
+		ids = append(ids, int64(u.ID))
+		names = append(names, u.Username)
+		keys = append(keys, key)
+		imageTimes = append(imageTimes, timestamp(u.ImageUpdatedAt))
+		bannedAt = append(bannedAt, timestamp(u.BannedAt))
+		banReasons = append(banReasons, u.BanReason)
+		deletionRequestedAt = append(deletionRequestedAt, timestamp(u.DeletionRequestedAt))
+		for _, r := range u.Roles {
+			roleUsers = append(roleUsers, int64(u.ID))
+			roles = append(roles, r.Role)
+			grantedAt = append(grantedAt, timestamp(r.GrantedAt))
+		}
+	}
+
+	_, err := im.tx.ExecContext(im.ctx,
+		`INSERT INTO users (id, username, image_key, image_updated_at, banned_at, ban_reason, deletion_requested_at)
+		SELECT id, username, NULLIF(image_key, ''), image_updated_at, NULLIF(banned_at, '')::timestamptz, ban_reason,
+			NULLIF(deletion_requested_at, '')::timestamptz
+		FROM unnest($1::int[], $2::text[], $3::text[], $4::timestamptz[], $5::text[], $6::text[], $7::text[])
+			AS u(id, username, image_key, image_updated_at, banned_at, ban_reason, deletion_requested_at)`,
+		pq.Array(ids), pq.Array(names), pq.Array(keys), pq.Array(imageTimes), pq.Array(bannedAt), pq.Array(banReasons),
+		pq.Array(deletionRequestedAt),
+	)
+	if err != nil {
+		return err
+	}
+	_, err = im.tx.ExecContext(im.ctx,
+		`INSERT INTO user_roles (user_id, role, granted_at) SELECT * FROM unnest($1::int[], $2::text[], $3::timestamptz[])`,
+		pq.Array(roleUsers), pq.Array(roles), pq.Array(grantedAt),
+	)
+	return err
+}
+
+func (im *importer) topics(batch []Topic) error {
+	var (
+		versions                              []int64
+		names, descriptions, keys, imageTimes []string
+	)
+	for _, t := range batch {
+		key, err := im.image(t.Image, "topics/"+t.Name)
+		if err != nil {
+			return err
+		}
+		names = append(names, t.Name)
+		descriptions = append(descriptions, t.Description)
+		keys = append(keys, key)
+		imageTimes = append(imageTimes, timestamp(t.ImageUpdatedAt))
+		versions = append(versions, int64(t.Version))
+	}
+	_, err := im.tx.ExecContext(im.ctx,
+		`INSERT INTO topics (name, description, image_key, image_updated_at, version)
+		SELECT name, description, NULLIF(image_key, ''), image_updated_at, version
+		FROM unnest($1::text[], $2::text[], $3::text[], $4::timestamptz[], $5::int[])
+			AS t(name, description, image_key, image_updated_at, version)`,
+		pq.Array(names), pq.Array(descriptions), pq.Array(keys), pq.Array(imageTimes), pq.Array(versions),
+	)
+	return err
+}
+
+func (im *importer) posts(batch []Post) error {
+	var (
+		ids, creators, versions                []int64
+		titles, bodies, topics, times, deleted []string
+		html                                   []string
+		edited                                 []bool
+	)
+	for _, p := range batch {
+		ids = append(ids, int64(p.ID))
+		titles = append(titles, p.Title)
+		bodies = append(bodies, p.Body)
+		html = append(html, markdown.Render(p.Body))
+		topics = append(topics, p.Topic)
+		creators = append(creators, int64(p.Creator))
+		times = append(times, timestamp(p.CreatedAt))
+		edited = append(edited, p.IsEdited)
+		versions = append(versions, int64(p.Version))
+		deleted = append(deleted, timestamp(p.DeletedAt))
+	}
+	_, err := im.tx.ExecContext(im.ctx,
+		`INSERT INTO posts (id, title, body, topic, creator, created_at, is_edited, version, deleted_at, body_html)
+		SELECT id, title, body, topic, creator, created_at, is_edited, version, NULLIF(deleted_at, '')::timestamptz, body_html
+		FROM unnest($1::int[], $2::text[], $3::text[], $4::text[], $5::int[], $6::timestamptz[], $7::bool[], $8::int[], $9::text[], $10::text[])
+			AS p(id, title, body, topic, creator, created_at, is_edited, version, deleted_at, body_html)`,
+		pq.Array(ids), pq.Array(titles), pq.Array(bodies), pq.Array(topics), pq.Array(creators),
+		pq.Array(times), pq.Array(edited), pq.Array(versions), pq.Array(deleted), pq.Array(html),
+	)
+	return err
+}
+
+func (im *importer) media(batch []Media) error {
+	var (
+		ids, uploaders, posts, positions []int64
+		alts, times, keys                []string
+	)
+	for _, m := range batch {
+		key, err := im.image(m.Image, "media/"+strconv.Itoa(m.Uploader))
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			return fmt.Errorf("media %d has no image", m.ID)
+		}
+		ids = append(ids, int64(m.ID))
+		uploaders = append(uploaders, int64(m.Uploader))
+		posts = append(posts, int64(m.Post))
+		alts = append(alts, m.Alt)
+		positions = append(positions, int64(m.Position))
+		times = append(times, timestamp(m.CreatedAt))
+		keys = append(keys, key)
+	}
+	_, err := im.tx.ExecContext(im.ctx,
+		`INSERT INTO media (id, uploader, post, alt, position, created_at, blob_key)
+		SELECT id, uploader, NULLIF(post, 0), alt, position, created_at, blob_key
+		FROM unnest($1::int[], $2::int[], $3::int[], $4::text[], $5::int[], $6::timestamptz[], $7::text[])
+			AS m(id, uploader, post, alt, position, created_at, blob_key)`,
+		pq.Array(ids), pq.Array(uploaders), pq.Array(posts), pq.Array(alts), pq.Array(positions),
+		pq.Array(times), pq.Array(keys),
+	)
+	return err
+}
+
+// comments relies on replies coming after the comments they answer, as
+// they do in exported archives, since a reply cannot be inserted in an
+// earlier batch than its parent.
+func (im *importer) comments(batch []Comment) error {
+	var (
+		ids, posts, creators, parents, versions []int64
+		bodies, html, times, deleted            []string
+		edited                                  []bool
+	)
+	for _, c := range batch {
+		ids = append(ids, int64(c.ID))
+		bodies = append(bodies, c.Body)
+		html = append(html, markdown.Render(c.Body))
+		posts = append(posts, int64(c.Post))
+		creators = append(creators, int64(c.Creator))
+		parents = append(parents, int64(c.Parent))
+		times = append(times, timestamp(c.CreatedAt))
+		edited = append(edited, c.IsEdited)
+		versions = append(versions, int64(c.Version))
+		deleted = append(deleted, timestamp(c.DeletedAt))
+	}
+	_, err := im.tx.ExecContext(im.ctx,
+		`INSERT INTO comments (id, body, post, creator, parent, created_at, is_edited, version, deleted_at, body_html)
+		SELECT id, body, post, creator, NULLIF(parent, 0), created_at, is_edited, version, NULLIF(deleted_at, '')::timestamptz, body_html
+		FROM unnest($1::int[], $2::text[], $3::int[], $4::int[], $5::int[], $6::timestamptz[], $7::bool[], $8::int[], $9::text[], $10::text[])
+			AS c(id, body, post, creator, parent, created_at, is_edited, version, deleted_at, body_html)`,
+		pq.Array(ids), pq.Array(bodies), pq.Array(posts), pq.Array(creators), pq.Array(parents),
+		pq.Array(times), pq.Array(edited), pq.Array(versions), pq.Array(deleted), pq.Array(html),
+	)
+	return err
+}
+
+func (im *importer) votes(batch []Vote) error {
+	var (
+		posts, users []int64
+		positive     []bool
+	)
+	for _, v := range batch {
+		posts = append(posts, int64(v.Post))
+		users = append(users, int64(v.User))
+		positive = append(positive, v.IsPositive)
+	}
+	_, err := im.tx.ExecContext(im.ctx,
+		`INSERT INTO post_votes (post_id, user_id, is_positive) SELECT * FROM unnest($1::int[], $2::int[], $3::bool[])`,
+		pq.Array(posts), pq.Array(users), pq.Array(positive),
+	)
+	return err
+}
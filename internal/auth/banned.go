@@ -0,0 +1,17 @@
+package auth
+
+import (
+	"net/http"
+
+	"backend/internal/problem"
+)
+
+// Banned is the problem sent to banned users, whether they log in or use a
+// token issued before the ban.
+func Banned(reason string) error {
+	detail := "This account is banned."
+	if reason != "" {
+		detail = "This account is banned: " + reason
+	}
+	return problem.New(http.StatusForbidden, "banned", detail)
+}
@@ -1,27 +1,78 @@
 package auth
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
 	"errors"
-	"os"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var secret = []byte(os.Getenv("JWT_SECRET"))
+// Tokens issues and checks the access tokens handed out at login. Tokens
+// are signed with the newest active key; older keys keep verifying the
+// tokens they signed until those have expired.
+type Tokens struct {
+	ttl time.Duration
+	kek cipher.AEAD // encrypts rotated keys in the database
 
-func GenerateToken(userID int) (string, error) {
+	mu   sync.RWMutex
+	keys []Key // oldest first, starting with JWT_SECRET
+}
+
+// Key is a secret that signs tokens. JWT_SECRET is the key with an empty
+// ID, older than every rotated one.
+type Key struct {
+	ID        string
+	CreatedAt time.Time
+	secret    []byte
+}
+
+func New(secret string, ttl time.Duration) *Tokens {
+	kek, err := hkdf.Key(sha256.New, []byte(secret), nil, "forum signing keys", 32)
+	if err != nil {
+		panic(err)
+	}
+	block, _ := aes.NewCipher(kek)
+	aead, _ := cipher.NewGCM(block)
+	return &Tokens{ttl: ttl, kek: aead, keys: []Key{{secret: []byte(secret)}}}
+}
+
+func (t *Tokens) Generate(userID int) (string, error) {
+	now := time.Now()
+	key := t.signingKey(now)
+
+	expiry := now.Add(t.ttl)
 	claims := jwt.MapClaims{
 		"userID":     userID,
-		"expiryDate": time.Now().Add(24 * time.Hour).Unix(),
+		"exp":        expiry.Unix(),
+		"expiryDate": expiry.Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secret)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.secret)
 }
 
-func VerifyToken(tokenString string) (int, error) {
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (any, error) { return secret, nil })
+func (t *Tokens) Verify(tokenString string) (int, error) {
+	now := time.Now()
+	token, err := jwt.Parse(tokenString,
+		func(token *jwt.Token) (any, error) {
+			kid, _ := token.Header["kid"].(string)
+			key, ok := t.verifyingKey(kid, now)
+			if !ok {
+				return nil, errors.New("unknown or retired key")
+			}
+			return key.secret, nil
+		},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	)
 	if err != nil || !token.Valid {
 		return 0, errors.New("Invalid token.")
 	}
@@ -35,3 +86,40 @@ func VerifyToken(tokenString string) (int, error) {
 	}
 	return int(uid), nil
 }
+
+// signingKey returns the newest key that is active at now.
+func (t *Tokens) signingKey(now time.Time) Key {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for i := len(t.keys) - 1; i > 0; i-- {
+		if !now.Before(activeAt(t.keys[i])) {
+			return t.keys[i]
+		}
+	}
+	return t.keys[0]
+}
+
+// verifyingKey returns the key with the given ID, unless every token it
+// signed has expired by now.
+func (t *Tokens) verifyingKey(id string, now time.Time) (Key, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for i, k := range t.keys {
+		if k.ID != id {
+			continue
+		}
+		if i+1 < len(t.keys) && !now.Before(t.retiresAt(t.keys[i+1])) {
+			return Key{}, false
+		}
+		return k, true
+	}
+	return Key{}, false
+}
+
+// retiresAt is when the key before next stops verifying tokens: once next
+// has signed every token that may still be unexpired.
+func (t *Tokens) retiresAt(next Key) time.Time {
+	return activeAt(next).Add(t.ttl)
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Rotated keys are stored in signing_keys encrypted with AES-GCM, under a
+// key derived from JWT_SECRET. That key never reaches the database, so a
+// copy of it, such as a backup, is not enough to forge tokens. Changing
+// JWT_SECRET makes the stored keys unreadable: Reload skips them, and the
+// next rotation deletes them once they would have retired.
+
+// ErrUnreadableKey is returned by Reload for stored keys that were not
+// encrypted under the current JWT_SECRET. The other keys are still loaded.
+var ErrUnreadableKey = errors.New("signing key was not encrypted with JWT_SECRET")
+
+const (
+	// KeyReloadInterval is how often the API picks up rotated keys.
+	KeyReloadInterval = 30 * time.Second
+	// keyActivation is how long a rotated key waits before it signs
+	// tokens, so that every API instance can verify them by then.
+	keyActivation = 2 * KeyReloadInterval
+)
+
+func activeAt(k Key) time.Time {
+	if k.ID == "" {
+		return time.Time{}
+	}
+	return k.CreatedAt.Add(keyActivation)
+}
+
+// KeyStatus describes a key as of the time Keys was called.
+type KeyStatus struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+	ActiveAt  time.Time `json:"active_at,omitzero"`
+	Signing   bool      `json:"signing"`
+	// RetiresAt is when the key stops verifying tokens, or zero while no
+	// newer key has been rotated in.
+	RetiresAt time.Time `json:"retires_at,omitzero"`
+}
+
+// Keys lists the keys that still verify tokens, oldest first.
+func (t *Tokens) Keys() []KeyStatus {
+	now := time.Now()
+	signing := t.signingKey(now)
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var keys []KeyStatus
+	for i, k := range t.keys {
+		s := KeyStatus{ID: k.ID, CreatedAt: k.CreatedAt, ActiveAt: activeAt(k), Signing: k.ID == signing.ID}
+		if i+1 < len(t.keys) {
+			s.RetiresAt = t.retiresAt(t.keys[i+1])
+			if !now.Before(s.RetiresAt) {
+				continue
+			}
+		}
+		keys = append(keys, s)
+	}
+	return keys
+}
+
+// Reload replaces the rotated keys with those stored in the database.
+func (t *Tokens) Reload(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, `SELECT id, secret, created_at FROM signing_keys ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var (
+		keys       []Key
+		unreadable error
+	)
+	for rows.Next() {
+		var (
+			k      Key
+			id     int
+			sealed []byte
+		)
+		if err := rows.Scan(&id, &sealed, &k.CreatedAt); err != nil {
+			return err
+		}
+		k.ID = strconv.Itoa(id)
+		if k.secret, err = t.open(sealed); err != nil {
+			unreadable = errors.Join(unreadable, fmt.Errorf("key %s: %w", k.ID, ErrUnreadableKey))
+			continue
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.keys = append(t.keys[:1:1], keys...)
+	t.mu.Unlock()
+	return unreadable
+}
+
+func (t *Tokens) seal(secret []byte) []byte {
+	nonce := make([]byte, t.kek.NonceSize(), t.kek.NonceSize()+len(secret)+t.kek.Overhead())
+	rand.Read(nonce)
+	return t.kek.Seal(nonce, nonce, secret, nil)
+}
+
+func (t *Tokens) open(sealed []byte) ([]byte, error) {
+	if len(sealed) < t.kek.NonceSize() {
+		return nil, ErrUnreadableKey
+	}
+	nonce, ciphertext := sealed[:t.kek.NonceSize()], sealed[t.kek.NonceSize():]
+	return t.kek.Open(nil, nonce, ciphertext, nil)
+}
+
+// Rotate stores a new random key, which starts signing tokens once every
+// API instance has had time to load it. Keys that no unexpired token can
+// have been signed with are deleted. It returns the new key and the
+// number deleted.
+func (t *Tokens) Rotate(ctx context.Context, db *sql.DB) (Key, int64, error) {
+	k := Key{secret: make([]byte, 32)}
+	rand.Read(k.secret)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Key{}, 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO signing_keys (secret) VALUES ($1) RETURNING id, created_at`,
+		t.seal(k.secret),
+	).Scan(&id, &k.CreatedAt)
+	if err != nil {
+		return Key{}, 0, err
+	}
+	k.ID = strconv.Itoa(id)
+
+	res, err := tx.ExecContext(ctx,
+		`DELETE FROM signing_keys k
+		WHERE EXISTS (
+			SELECT 1 FROM signing_keys n
+			WHERE n.id > k.id AND n.created_at < now() - make_interval(secs => $1)
+		)`,
+		(keyActivation + t.ttl).Seconds(),
+	)
+	if err != nil {
+		return Key{}, 0, err
+	}
+	deleted, _ := res.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return Key{}, 0, err
+	}
+	if err := t.Reload(ctx, db); err != nil && !errors.Is(err, ErrUnreadableKey) {
+		return Key{}, 0, err
+	}
+	return k, deleted, nil
+}
@@ -0,0 +1,46 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps binary objects such as images outside of Postgres. Keys are
+// slash-separated paths like "users/12/<sha256>".
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	// Ping reports whether the store can currently be reached.
+	Ping(ctx context.Context) error
+}
+
+// Key returns a content-addressed key under prefix, so replacing an image
+// always produces a new key and a given key never changes content.
+func Key(prefix string, data []byte) string {
+	sum := sha256.Sum256(data)
+	return prefix + "/" + hex.EncodeToString(sum[:])
+}
+
+type Config struct {
+	Backend string // "fs" or "s3"
+	Dir     string
+	S3      S3Config
+}
+
+// Open builds the store selected by cfg.Backend.
+func Open(cfg Config) (Store, error) {
+	switch cfg.Backend {
+	case "", "fs":
+		return NewFS(cfg.Dir)
+	case "s3":
+		return NewS3(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown blob backend %q", cfg.Backend)
+	}
+}
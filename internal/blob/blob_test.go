@@ -0,0 +1,109 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"fs": func(t *testing.T) Store {
+			s, err := NewFS(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+		"memory": func(t *testing.T) Store { return NewMemory() },
+		// Runs against any S3-compatible service, such as the minio one in
+		// docker-compose.yml, when TEST_S3_ENDPOINT names it.
+		"s3": func(t *testing.T) Store {
+			endpoint := os.Getenv("TEST_S3_ENDPOINT")
+			if endpoint == "" {
+				t.Skip("TEST_S3_ENDPOINT is not set")
+			}
+			s, err := NewS3(S3Config{
+				Endpoint:  endpoint,
+				Bucket:    "forum-blob-test",
+				AccessKey: os.Getenv("TEST_S3_ACCESS_KEY"),
+				SecretKey: os.Getenv("TEST_S3_SECRET_KEY"),
+				UseSSL:    os.Getenv("TEST_S3_SSL") != "",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+	}
+
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := open(t)
+			data := []byte("image bytes")
+			key := Key("media/1", data)
+
+			if _, err := s.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Get before Put: err = %v, want ErrNotFound", err)
+			}
+			if err := s.Put(ctx, key, data); err != nil {
+				t.Fatal(err)
+			}
+			// Identical uploads store the same blob again.
+			if err := s.Put(ctx, key, data); err != nil {
+				t.Fatal(err)
+			}
+			got, err := s.Get(ctx, key)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("Get = %q, %v; want %q", got, err, data)
+			}
+
+			if err := s.Delete(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Get after Delete: err = %v, want ErrNotFound", err)
+			}
+			if err := s.Delete(ctx, key); err != nil {
+				t.Fatalf("deleting a missing blob: %v", err)
+			}
+			if err := s.Ping(ctx); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	a, b := []byte("a"), []byte("b")
+	tests := []struct {
+		name  string
+		k1    string
+		k2    string
+		equal bool
+	}{
+		{"same content", Key("media/1", a), Key("media/1", a), true},
+		{"other content", Key("media/1", a), Key("media/1", b), false},
+		{"other prefix", Key("media/1", a), Key("media/2", a), false},
+	}
+	for _, tt := range tests {
+		if (tt.k1 == tt.k2) != tt.equal {
+			t.Errorf("%s: keys %q and %q, want equal = %v", tt.name, tt.k1, tt.k2, tt.equal)
+		}
+	}
+}
+
+func TestFSRejectsKeysOutsideRoot(t *testing.T) {
+	s, err := NewFS(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"../escape", "/etc/passwd", "media/../../escape", ""} {
+		if err := s.Put(context.Background(), key, []byte("x")); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+	}
+}
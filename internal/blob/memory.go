@@ -0,0 +1,57 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"sync"
+)
+
+// Memory keeps blobs in a map. It loses everything when the process exits,
+// so it only stands in for a real store in tests.
+type Memory struct {
+	mu    sync.RWMutex
+	blobs map[string][]byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{blobs: map[string][]byte{}}
+}
+
+func (s *Memory) Put(ctx context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[key] = bytes.Clone(data)
+	return nil
+}
+
+func (s *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.blobs[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return bytes.Clone(data), nil
+}
+
+func (s *Memory) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blobs, key)
+	return nil
+}
+
+func (s *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Keys returns the keys of all blobs, in no particular order.
+func (s *Memory) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.blobs))
+	for k := range s.blobs {
+		keys = append(keys, k)
+	}
+	return keys
+}
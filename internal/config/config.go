@@ -0,0 +1,480 @@
+// Package config loads the server's settings from, in increasing order of
+// precedence, built-in defaults, an optional JSON file, the environment and
+// command-line flags, and rejects unusable values before anything starts.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/blob"
+	"backend/internal/origin"
+)
+
+type Config struct {
+	ListenAddr string
+	Log        Log
+	Tracing    Tracing
+	Server     Server
+	Database   Database
+	Auth       Auth
+	CORS       CORS
+	RateLimit  RateLimit
+	Blob       blob.Config
+	Limits     Limits
+}
+
+type Log struct {
+	Format string // "json" or "text"
+	Level  string
+}
+
+type Tracing struct {
+	Exporter string // "none", "stdout" or "otlp"
+	// OTLPEndpoint is the URL of an OTLP/HTTP collector, such as
+	// http://localhost:4318.
+	OTLPEndpoint string
+	// SampleRatio is the fraction of traces started here that are
+	// recorded. Requests that arrive with a trace keep its decision.
+	SampleRatio float64
+}
+
+type Server struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout bounds how long in-flight requests may take to
+	// finish after SIGTERM before their connections are closed.
+	ShutdownTimeout time.Duration
+	// TrustedProxies are the addresses of reverse proxies whose
+	// X-Forwarded-For header is believed when working out client IPs.
+	TrustedProxies []netip.Prefix
+	// HSTSMaxAge is how long browsers should only use HTTPS for the API's
+	// host. Zero leaves Strict-Transport-Security out.
+	HSTSMaxAge time.Duration
+}
+
+type Database struct {
+	URL             string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	// ConnectTimeout bounds how long startup waits for the database.
+	ConnectTimeout time.Duration
+}
+
+type Auth struct {
+	// Secret signs access tokens. It must be long and random, as anyone
+	// who knows it can log in as any user.
+	Secret   string
+	TokenTTL time.Duration
+}
+
+type CORS struct {
+	// Origins lists the browser origins allowed to call the API, such as
+	// https://forum.example.com or https://*.example.com. It defaults to
+	// the deployed frontend and the Vite dev server. Empty allows none,
+	// which suits clients other than browsers.
+	Origins []string
+	// MaxAge is how long browsers may cache preflight responses.
+	MaxAge time.Duration
+}
+
+type RateLimit struct {
+	Backend string // "memory" or "postgres"
+}
+
+type Limits struct {
+	MaxBodyBytes   int64
+	IdempotencyTTL time.Duration
+	// IdempotencyLease is how long a request holds its Idempotency-Key.
+	// A retry after that takes the key over, so a request lost to a crash
+	// does not block its key until IdempotencyTTL runs out.
+	IdempotencyLease time.Duration
+	MediaMaxAge      time.Duration
+	// DeletionGrace is how long users can cancel the deletion of their
+	// account before it happens.
+	DeletionGrace time.Duration
+}
+
+func defaults() *Config {
+	return &Config{
+		ListenAddr: ":8080",
+		Log: Log{
+			Format: "json",
+			Level:  "info",
+		},
+		Tracing: Tracing{
+			Exporter:     "none",
+			OTLPEndpoint: "http://localhost:4318",
+			SampleRatio:  1,
+		},
+		Server: Server{
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      60 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   20 * time.Second,
+			HSTSMaxAge:        365 * 24 * time.Hour,
+		},
+		Database: Database{
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnectTimeout:  time.Minute,
+		},
+		Auth: Auth{
+			TokenTTL: 24 * time.Hour,
+		},
+		CORS: CORS{
+			Origins: []string{"https://cvwo-frontend-salmonkarp.netlify.app", "http://localhost:5173"},
+			MaxAge:  10 * time.Minute,
+		},
+		RateLimit: RateLimit{
+			Backend: "memory",
+		},
+		Blob: blob.Config{
+			Backend: "fs",
+			Dir:     "data/blobs",
+			S3:      blob.S3Config{UseSSL: true},
+		},
+		Limits: Limits{
+			MaxBodyBytes:     8 << 20,
+			IdempotencyTTL:   24 * time.Hour,
+			IdempotencyLease: 2 * time.Minute,
+			MediaMaxAge:      24 * time.Hour,
+			DeletionGrace:    14 * 24 * time.Hour,
+		},
+	}
+}
+
+// setting is one configurable value. Its name is the key in the config
+// file and, with underscores turned into dashes, the flag name.
+type setting struct {
+	name   string
+	env    string
+	usage  string
+	secret bool // never accepted as a flag, where it would show up in ps
+	set    func(string) error
+}
+
+func settings(c *Config) []setting {
+	return []setting{
+		{"listen_addr", "LISTEN_ADDR", "address to serve HTTP on", false, stringVar(&c.ListenAddr)},
+		{"log_format", "LOG_FORMAT", `log output: "json" or "text"`, false, stringVar(&c.Log.Format)},
+		{"log_level", "LOG_LEVEL", "least severe level logged: debug, info, warn or error", false, stringVar(&c.Log.Level)},
+		{"read_header_timeout", "READ_HEADER_TIMEOUT", "time allowed to read request headers", false, durationVar(&c.Server.ReadHeaderTimeout)},
+		{"read_timeout", "READ_TIMEOUT", "time allowed to read a whole request", false, durationVar(&c.Server.ReadTimeout)},
+		{"write_timeout", "WRITE_TIMEOUT", "time allowed to write a response", false, durationVar(&c.Server.WriteTimeout)},
+		{"idle_timeout", "IDLE_TIMEOUT", "how long idle keep-alive connections stay open", false, durationVar(&c.Server.IdleTimeout)},
+		{"shutdown_timeout", "SHUTDOWN_TIMEOUT", "how long to wait for in-flight requests on shutdown", false, durationVar(&c.Server.ShutdownTimeout)},
+		{"trusted_proxies", "TRUSTED_PROXIES", "comma-separated IPs or CIDRs of reverse proxies to take client IPs from", false, prefixListVar(&c.Server.TrustedProxies)},
+		{"hsts_max_age", "HSTS_MAX_AGE", "Strict-Transport-Security max-age, 0 to leave the header out", false, durationVar(&c.Server.HSTSMaxAge)},
+
+		{"tracing_exporter", "TRACING_EXPORTER", `where spans are sent: "none", "stdout" or "otlp"`, false, stringVar(&c.Tracing.Exporter)},
+		{"otlp_endpoint", "OTLP_ENDPOINT", "URL of the OTLP/HTTP trace collector", false, stringVar(&c.Tracing.OTLPEndpoint)},
+		{"trace_sample_ratio", "TRACE_SAMPLE_RATIO", "fraction of new traces that are recorded, from 0 to 1", false, floatVar(&c.Tracing.SampleRatio)},
+
+		{"database_url", "DATABASE_URL", "Postgres connection string", false, stringVar(&c.Database.URL)},
+		{"db_max_open_conns", "DB_MAX_OPEN_CONNS", "maximum open database connections", false, intVar(&c.Database.MaxOpenConns)},
+		{"db_max_idle_conns", "DB_MAX_IDLE_CONNS", "maximum idle database connections", false, intVar(&c.Database.MaxIdleConns)},
+		{"db_conn_max_lifetime", "DB_CONN_MAX_LIFETIME", "how long a database connection may be reused", false, durationVar(&c.Database.ConnMaxLifetime)},
+		{"db_connect_timeout", "DB_CONNECT_TIMEOUT", "how long to wait for the database at startup", false, durationVar(&c.Database.ConnectTimeout)},
+
+		{"jwt_secret", "JWT_SECRET", "key that signs access tokens", true, stringVar(&c.Auth.Secret)},
+		{"token_ttl", "TOKEN_TTL", "how long access tokens stay valid", false, durationVar(&c.Auth.TokenTTL)},
+
+		{"cors_origins", "CORS_ORIGINS", "comma-separated browser origins allowed to call the API, e.g. https://*.example.com", false, listVar(&c.CORS.Origins)},
+		{"cors_max_age", "CORS_MAX_AGE", "how long browsers may cache preflight responses", false, durationVar(&c.CORS.MaxAge)},
+
+		{"rate_limit_backend", "RATE_LIMIT_BACKEND", `where rate limit buckets are kept: "memory" or "postgres"`, false, stringVar(&c.RateLimit.Backend)},
+
+		{"blob_backend", "BLOB_BACKEND", `where images are stored: "fs" or "s3"`, false, stringVar(&c.Blob.Backend)},
+		{"blob_dir", "BLOB_DIR", "directory of the fs blob backend", false, stringVar(&c.Blob.Dir)},
+		{"s3_endpoint", "S3_ENDPOINT", "host:port of the S3 service", false, stringVar(&c.Blob.S3.Endpoint)},
+		{"s3_bucket", "S3_BUCKET", "S3 bucket for images", false, stringVar(&c.Blob.S3.Bucket)},
+		{"s3_region", "S3_REGION", "S3 region", false, stringVar(&c.Blob.S3.Region)},
+		{"s3_access_key", "S3_ACCESS_KEY", "S3 access key", false, stringVar(&c.Blob.S3.AccessKey)},
+		{"s3_secret_key", "S3_SECRET_KEY", "S3 secret key", true, stringVar(&c.Blob.S3.SecretKey)},
+		{"s3_insecure", "S3_INSECURE", "talk to S3 over plain HTTP", false, invertedBoolVar(&c.Blob.S3.UseSSL)},
+
+		{"max_body_bytes", "MAX_BODY_BYTES", "largest accepted request body", false, int64Var(&c.Limits.MaxBodyBytes)},
+		{"idempotency_ttl", "IDEMPOTENCY_TTL", "how long responses are kept for Idempotency-Key replays", false, durationVar(&c.Limits.IdempotencyTTL)},
+		{"idempotency_lease", "IDEMPOTENCY_LEASE", "how long a request holds its Idempotency-Key before a retry may take it over", false, durationVar(&c.Limits.IdempotencyLease)},
+		{"media_max_age", "MEDIA_MAX_AGE", "how long uploads may stay unattached before they are deleted", false, durationVar(&c.Limits.MediaMaxAge)},
+		{"deletion_grace", "DELETION_GRACE", "how long users can cancel the deletion of their account", false, durationVar(&c.Limits.DeletionGrace)},
+	}
+}
+
+// Load reads the configuration. args are the command-line arguments
+// without the program name; -config or CONFIG_FILE names the JSON file.
+func Load(args []string) (*Config, error) {
+	c := defaults()
+	all := settings(c)
+
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON file with settings keyed by flag name, using underscores ($CONFIG_FILE)")
+	flags := map[string]string{}
+	for _, s := range all {
+		if s.secret {
+			continue
+		}
+		fs.Func(strings.ReplaceAll(s.name, "_", "-"), s.usage+" ($"+s.env+")", func(v string) error {
+			flags[s.name] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *file != "" {
+		if err := loadFile(*file, all); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range all {
+		if v := os.Getenv(s.env); v != "" {
+			if err := s.set(v); err != nil {
+				return nil, fmt.Errorf("config: %s: %w", s.env, err)
+			}
+		}
+	}
+
+	for _, s := range all {
+		if v, ok := flags[s.name]; ok {
+			if err := s.set(v); err != nil {
+				return nil, fmt.Errorf("config: -%s: %w", strings.ReplaceAll(s.name, "_", "-"), err)
+			}
+		}
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func loadFile(path string, all []setting) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	var values map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+
+	for key, value := range values {
+		i := slices.IndexFunc(all, func(s setting) bool { return s.name == key })
+		if i < 0 {
+			return fmt.Errorf("config: %s: unknown setting %q", path, key)
+		}
+
+		var v string
+		switch value := value.(type) {
+		case []any:
+			parts := make([]string, len(value))
+			for i, p := range value {
+				parts[i] = fmt.Sprint(p)
+			}
+			v = strings.Join(parts, ",")
+		default:
+			v = fmt.Sprint(value)
+		}
+		if err := all[i].set(v); err != nil {
+			return fmt.Errorf("config: %s: %s: %w", path, key, err)
+		}
+	}
+	return nil
+}
+
+// minSecretLength is the shortest JWT_SECRET accepted, in bytes. HS256
+// keys shorter than the hash output make brute-forcing tokens easier.
+const minSecretLength = 32
+
+func (c *Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("config: "+format, args...))
+		}
+	}
+
+	check(c.ListenAddr != "", "LISTEN_ADDR is required")
+	check(c.Log.Format == "json" || c.Log.Format == "text", "LOG_FORMAT must be json or text, not %q", c.Log.Format)
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "LOG_LEVEL must be debug, info, warn or error, not %q", c.Log.Level)
+	check(slices.Contains([]string{"none", "stdout", "otlp"}, c.Tracing.Exporter),
+		"TRACING_EXPORTER must be none, stdout or otlp, not %q", c.Tracing.Exporter)
+	if c.Tracing.Exporter == "otlp" {
+		check(c.Tracing.OTLPEndpoint != "", "OTLP_ENDPOINT is required with TRACING_EXPORTER=otlp")
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "TRACE_SAMPLE_RATIO must be between 0 and 1")
+	check(c.Server.ReadHeaderTimeout > 0, "READ_HEADER_TIMEOUT must be positive")
+	check(c.Server.ReadTimeout >= 0, "READ_TIMEOUT must not be negative")
+	check(c.Server.WriteTimeout >= 0, "WRITE_TIMEOUT must not be negative")
+	check(c.Server.IdleTimeout >= 0, "IDLE_TIMEOUT must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "SHUTDOWN_TIMEOUT must be positive")
+	check(c.Database.URL != "", "DATABASE_URL is required")
+	check(c.Database.MaxOpenConns >= 0, "DB_MAX_OPEN_CONNS must not be negative")
+	check(c.Database.MaxIdleConns >= 0, "DB_MAX_IDLE_CONNS must not be negative")
+	check(c.Database.ConnectTimeout > 0, "DB_CONNECT_TIMEOUT must be positive")
+
+	check(c.Auth.Secret != "", "JWT_SECRET is required")
+	check(c.Auth.Secret == "" || !weakSecret(c.Auth.Secret),
+		"JWT_SECRET is too weak; use at least %d random bytes, e.g. from `openssl rand -base64 48`", minSecretLength)
+	check(c.Auth.TokenTTL > 0, "TOKEN_TTL must be positive")
+
+	for _, o := range c.CORS.Origins {
+		check(origin.Valid(o), "CORS_ORIGINS: %q is not an origin like https://example.com or https://*.example.com", o)
+	}
+	check(c.CORS.MaxAge >= 0, "CORS_MAX_AGE must not be negative")
+	check(c.Server.HSTSMaxAge >= 0, "HSTS_MAX_AGE must not be negative")
+
+	check(c.RateLimit.Backend == "memory" || c.RateLimit.Backend == "postgres",
+		"RATE_LIMIT_BACKEND must be memory or postgres, not %q", c.RateLimit.Backend)
+
+	check(c.Blob.Backend == "fs" || c.Blob.Backend == "s3", "BLOB_BACKEND must be fs or s3, not %q", c.Blob.Backend)
+	if c.Blob.Backend == "s3" {
+		check(c.Blob.S3.Endpoint != "" && c.Blob.S3.Bucket != "", "S3_ENDPOINT and S3_BUCKET are required with BLOB_BACKEND=s3")
+	}
+
+	check(c.Limits.MaxBodyBytes > 0, "MAX_BODY_BYTES must be positive")
+	check(c.Limits.IdempotencyTTL > 0, "IDEMPOTENCY_TTL must be positive")
+	check(c.Limits.IdempotencyLease > 0, "IDEMPOTENCY_LEASE must be positive")
+	check(c.Limits.IdempotencyLease <= c.Limits.IdempotencyTTL, "IDEMPOTENCY_LEASE must not be longer than IDEMPOTENCY_TTL")
+	check(c.Server.WriteTimeout == 0 || c.Limits.IdempotencyLease > c.Server.WriteTimeout,
+		"IDEMPOTENCY_LEASE must be longer than WRITE_TIMEOUT")
+	check(c.Limits.MediaMaxAge > 0, "MEDIA_MAX_AGE must be positive")
+	check(c.Limits.DeletionGrace >= 0, "DELETION_GRACE must not be negative")
+
+	return errors.Join(errs...)
+}
+
+// weakSecret rejects secrets that are short or obviously not random.
+func weakSecret(s string) bool {
+	if len(s) < minSecretLength {
+		return true
+	}
+	distinct := map[rune]bool{}
+	for _, r := range s {
+		distinct[r] = true
+	}
+	return len(distinct) < 8
+}
+
+func stringVar(p *string) func(string) error {
+	return func(v string) error {
+		*p = v
+		return nil
+	}
+}
+
+func intVar(p *int) func(string) error {
+	return func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return errors.New("not an integer")
+		}
+		*p = n
+		return nil
+	}
+}
+
+func int64Var(p *int64) func(string) error {
+	return func(v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return errors.New("not an integer")
+		}
+		*p = n
+		return nil
+	}
+}
+
+func floatVar(p *float64) func(string) error {
+	return func(v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return errors.New("not a number")
+		}
+		*p = f
+		return nil
+	}
+}
+
+func durationVar(p *time.Duration) func(string) error {
+	return func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return errors.New(`not a duration like "30s" or "24h"`)
+		}
+		*p = d
+		return nil
+	}
+}
+
+func listVar(p *[]string) func(string) error {
+	return func(v string) error {
+		*p = nil
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*p = append(*p, item)
+			}
+		}
+		return nil
+	}
+}
+
+// prefixListVar accepts both CIDRs and single addresses.
+func prefixListVar(p *[]netip.Prefix) func(string) error {
+	return func(v string) error {
+		*p = nil
+		for _, item := range strings.Split(v, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				addr, addrErr := netip.ParseAddr(item)
+				if addrErr != nil {
+					return fmt.Errorf("%q is not an IP address or CIDR", item)
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			*p = append(*p, prefix)
+		}
+		return nil
+	}
+}
+
+// invertedBoolVar sets *p to false for any true-ish value, for settings
+// like S3_INSECURE that turn something off.
+func invertedBoolVar(p *bool) func(string) error {
+	return func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.New("not a boolean")
+		}
+		*p = !b
+		return nil
+	}
+}
@@ -0,0 +1,227 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "k3R9xq2LmV8pZt4Wb6Ny1Hc5Jd7Fg0Ae"
+
+// clearEnv hides the settings of the environment the tests run in.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("CONFIG_FILE", "")
+	for _, s := range settings(defaults()) {
+		t.Setenv(s.env, "")
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name string
+		file string // JSON, passed with -config
+		env  map[string]string
+		args []string
+
+		wantAddr    string
+		wantTTL     time.Duration
+		wantOrigins []string
+		wantErr     string
+	}{
+		{
+			name:        "defaults",
+			wantAddr:    ":8080",
+			wantTTL:     24 * time.Hour,
+			wantOrigins: []string{"https://cvwo-frontend-salmonkarp.netlify.app", "http://localhost:5173"},
+		},
+		{
+			name:        "file over defaults",
+			file:        `{"listen_addr": ":9000", "token_ttl": "1h", "cors_origins": ["https://a.example.com", "https://*.b.example.com"]}`,
+			wantAddr:    ":9000",
+			wantTTL:     time.Hour,
+			wantOrigins: []string{"https://a.example.com", "https://*.b.example.com"},
+		},
+		{
+			name:        "env over file",
+			file:        `{"listen_addr": ":9000", "token_ttl": "1h"}`,
+			env:         map[string]string{"LISTEN_ADDR": ":9001", "CORS_ORIGINS": "https://a.example.com, ,https://c.example.com"},
+			wantAddr:    ":9001",
+			wantTTL:     time.Hour,
+			wantOrigins: []string{"https://a.example.com", "https://c.example.com"},
+		},
+		{
+			name:        "flags over env",
+			file:        `{"listen_addr": ":9000"}`,
+			env:         map[string]string{"LISTEN_ADDR": ":9001", "TOKEN_TTL": "2h"},
+			args:        []string{"-listen-addr", ":9002", "-cors-origins", ""},
+			wantAddr:    ":9002",
+			wantTTL:     2 * time.Hour,
+			wantOrigins: nil,
+		},
+		{
+			name:     "file named by the environment",
+			env:      map[string]string{"CONFIG_FILE": writeFile(t, `{"listen_addr": ":9003"}`)},
+			wantAddr: ":9003", wantTTL: 24 * time.Hour,
+			wantOrigins: []string{"https://cvwo-frontend-salmonkarp.netlify.app", "http://localhost:5173"},
+		},
+		{
+			name:    "secret as flag",
+			args:    []string{"-jwt-secret", testSecret},
+			wantErr: "flag provided but not defined: -jwt-secret",
+		},
+		{
+			name:     "secret from env over file",
+			file:     `{"jwt_secret": "` + strings.Repeat("ab", 20) + `"}`,
+			wantAddr: ":8080", wantTTL: 24 * time.Hour,
+			wantOrigins: []string{"https://cvwo-frontend-salmonkarp.netlify.app", "http://localhost:5173"},
+		},
+		{
+			name:    "weak secret from file",
+			file:    `{"jwt_secret": "` + strings.Repeat("ab", 20) + `"}`,
+			env:     map[string]string{"JWT_SECRET": ""},
+			wantErr: "JWT_SECRET is too weak",
+		},
+		{
+			name:    "unknown file setting",
+			file:    `{"listen_address": ":9000"}`,
+			wantErr: `unknown setting "listen_address"`,
+		},
+		{
+			name:    "malformed file",
+			file:    `{"listen_addr": `,
+			wantErr: "unexpected EOF",
+		},
+		{
+			name:    "invalid env value",
+			env:     map[string]string{"TOKEN_TTL": "a day"},
+			wantErr: `config: TOKEN_TTL: not a duration like "30s" or "24h"`,
+		},
+		{
+			name:    "invalid flag value",
+			args:    []string{"-db-max-open-conns", "many"},
+			wantErr: "config: -db-max-open-conns: not an integer",
+		},
+		{
+			name:    "invalid after all sources",
+			env:     map[string]string{"CORS_ORIGINS": "https://example.com/app"},
+			wantErr: `CORS_ORIGINS: "https://example.com/app" is not an origin`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			t.Setenv("DATABASE_URL", "postgres://localhost/forum")
+			t.Setenv("JWT_SECRET", testSecret)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, tt.file)}, args...)
+			}
+
+			c, err := Load(args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.ListenAddr != tt.wantAddr {
+				t.Errorf("ListenAddr = %q, want %q", c.ListenAddr, tt.wantAddr)
+			}
+			if c.Auth.TokenTTL != tt.wantTTL {
+				t.Errorf("TokenTTL = %v, want %v", c.Auth.TokenTTL, tt.wantTTL)
+			}
+			if !slices.Equal(c.CORS.Origins, tt.wantOrigins) {
+				t.Errorf("CORS.Origins = %q, want %q", c.CORS.Origins, tt.wantOrigins)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		wantErr string // "" when the config is valid
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"no database", func(c *Config) { c.Database.URL = "" }, "DATABASE_URL is required"},
+		{"no secret", func(c *Config) { c.Auth.Secret = "" }, "JWT_SECRET is required"},
+		{"short secret", func(c *Config) { c.Auth.Secret = testSecret[:minSecretLength-1] }, "JWT_SECRET is too weak"},
+		{"repetitive secret", func(c *Config) { c.Auth.Secret = strings.Repeat("abcd", 16) }, "JWT_SECRET is too weak"},
+		{"log format", func(c *Config) { c.Log.Format = "xml" }, `LOG_FORMAT must be json or text, not "xml"`},
+		{"log level", func(c *Config) { c.Log.Level = "loud" }, "LOG_LEVEL must be"},
+		{"tracing exporter", func(c *Config) { c.Tracing.Exporter = "jaeger" }, "TRACING_EXPORTER must be"},
+		{"otlp without endpoint", func(c *Config) {
+			c.Tracing.Exporter, c.Tracing.OTLPEndpoint = "otlp", ""
+		}, "OTLP_ENDPOINT is required"},
+		{"sample ratio", func(c *Config) { c.Tracing.SampleRatio = 1.5 }, "TRACE_SAMPLE_RATIO must be between 0 and 1"},
+		{"read header timeout", func(c *Config) { c.Server.ReadHeaderTimeout = 0 }, "READ_HEADER_TIMEOUT must be positive"},
+		{"negative write timeout", func(c *Config) { c.Server.WriteTimeout = -time.Second }, "WRITE_TIMEOUT must not be negative"},
+		{"wildcard origin", func(c *Config) { c.CORS.Origins = []string{"https://*.example.com"} }, ""},
+		{"invalid origin", func(c *Config) { c.CORS.Origins = []string{"*"} }, `CORS_ORIGINS: "*" is not an origin`},
+		{"rate limit backend", func(c *Config) { c.RateLimit.Backend = "redis" }, "RATE_LIMIT_BACKEND must be"},
+		{"blob backend", func(c *Config) { c.Blob.Backend = "gcs" }, "BLOB_BACKEND must be fs or s3"},
+		{"s3 without bucket", func(c *Config) {
+			c.Blob.Backend, c.Blob.S3.Endpoint = "s3", "localhost:9000"
+		}, "S3_ENDPOINT and S3_BUCKET are required"},
+		{"body limit", func(c *Config) { c.Limits.MaxBodyBytes = 0 }, "MAX_BODY_BYTES must be positive"},
+		{"lease over ttl", func(c *Config) { c.Limits.IdempotencyLease = 48 * time.Hour }, "IDEMPOTENCY_LEASE must not be longer than IDEMPOTENCY_TTL"},
+		{"lease under write timeout", func(c *Config) { c.Limits.IdempotencyLease = time.Second }, "IDEMPOTENCY_LEASE must be longer than WRITE_TIMEOUT"},
+		{"lease without write timeout", func(c *Config) {
+			c.Server.WriteTimeout, c.Limits.IdempotencyLease = 0, time.Second
+		}, ""},
+		{"deletion grace", func(c *Config) { c.Limits.DeletionGrace = -time.Hour }, "DELETION_GRACE must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaults()
+			c.Database.URL = "postgres://localhost/forum"
+			c.Auth.Secret = testSecret
+			tt.change(c)
+
+			err := c.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validate error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Every problem is reported at once, so all can be fixed in one go.
+func TestValidateReportsAll(t *testing.T) {
+	c := defaults()
+	err := c.validate()
+	if err == nil {
+		t.Fatal("validate accepted a config without DATABASE_URL and JWT_SECRET")
+	}
+	for _, want := range []string{"DATABASE_URL is required", "JWT_SECRET is required"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("validate error %q lacks %q", err, want)
+		}
+	}
+}
@@ -1,16 +1,97 @@
 package db
 
 import (
+	"context"
 	"database/sql"
-	"os"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
 
+	"backend/internal/config"
+
+	"github.com/XSAM/otelsql"
 	_ "github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 var Conn *sql.DB
 
-func Connect() error {
+// Connect opens the connection pool and waits for the database to answer,
+// retrying with exponential backoff for up to cfg.ConnectTimeout. The
+// database container often starts more slowly than the API.
+func Connect(ctx context.Context, cfg config.Database) error {
 	var err error
-	Conn, err = sql.Open("postgres", os.Getenv("DATABASE_URL"))
-	return err
+	Conn, err = otelsql.Open("postgres", cfg.URL,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanNameFormatter(spanName),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			DisableErrSkip:       true,
+			OmitConnResetSession: true,
+			OmitConnPrepare:      true,
+			OmitRows:             true,
+			OmitConnectorConnect: true,
+		}),
+	)
+	if err != nil {
+		return err
+	}
+	Conn.SetMaxOpenConns(cfg.MaxOpenConns)
+	Conn.SetMaxIdleConns(cfg.MaxIdleConns)
+	Conn.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.ConnectTimeout)
+	defer cancel()
+
+	backoff := 250 * time.Millisecond
+	for {
+		err = Conn.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		slog.Warn("Database not reachable yet", "retry_in", backoff, "err", err)
+
+		select {
+		case <-ctx.Done():
+			Conn.Close()
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 10*time.Second)
+	}
+}
+
+var (
+	// statementName matches the "-- name: X" comment queries in the store
+	// package start with.
+	statementName = regexp.MustCompile(`^\s*-- name: (\S+)`)
+	// statementTable finds the table a statement is mainly about: the
+	// first one it reads from, inserts into or updates.
+	statementTable = regexp.MustCompile(`(?i)\b(?:FROM|INTO|UPDATE)\s+([a-z_][a-z0-9_]*)`)
+	subquery       = regexp.MustCompile(`\([^()]*\)`)
+)
+
+// spanName names database spans after the statement, so they can be told
+// apart without recording parameters: by its name comment if it has one,
+// or else by what it does to which table, such as "UPDATE posts". The SQL
+// text, which holds only placeholders, is kept in db.statement.
+func spanName(ctx context.Context, method otelsql.Method, query string) string {
+	if m := statementName.FindStringSubmatch(query); m != nil {
+		return m[1]
+	}
+
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return string(method)
+	}
+	name := strings.ToUpper(fields[0])
+
+	// Look at the outermost statement only, not at subqueries.
+	for outer := ""; outer != query; {
+		outer, query = query, subquery.ReplaceAllString(query, "")
+	}
+	if m := statementTable.FindStringSubmatch(query); m != nil {
+		name += " " + strings.ToLower(m[1])
+	}
+	return name
 }
@@ -0,0 +1,43 @@
+-- Tables that predate versioned migrations. Existing databases already have
+-- them, so every statement is a no-op there.
+
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    username TEXT NOT NULL UNIQUE,
+    image BYTEA,
+    image_updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS topics (
+    name TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    image BYTEA,
+    image_updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS posts (
+    id SERIAL PRIMARY KEY,
+    title TEXT NOT NULL,
+    body TEXT NOT NULL,
+    topic TEXT NOT NULL REFERENCES topics(name) ON DELETE CASCADE,
+    creator INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    is_edited BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS comments (
+    id SERIAL PRIMARY KEY,
+    body TEXT NOT NULL,
+    post INT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    creator INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    is_edited BOOLEAN NOT NULL DEFAULT FALSE,
+    parent INT REFERENCES comments(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS post_votes (
+    post_id INT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    is_positive BOOLEAN NOT NULL,
+    PRIMARY KEY (post_id, user_id)
+);
@@ -0,0 +1,14 @@
+-- Images uploaded through /media. Rows start without a post and are
+-- attached by AddPost/EditPost; detached rows are pruned after a while.
+
+CREATE TABLE IF NOT EXISTS media (
+    id SERIAL PRIMARY KEY,
+    uploader INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    data BYTEA NOT NULL,
+    post INT REFERENCES posts(id) ON DELETE SET NULL,
+    alt TEXT NOT NULL DEFAULT '',
+    position INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS media_post_idx ON media (post, position);
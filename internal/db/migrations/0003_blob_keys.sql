@@ -0,0 +1,8 @@
+-- Images move out of bytea columns into the blob store. Rows keep their
+-- legacy bytea until cmd/migrateimages has copied it across.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS image_key TEXT;
+ALTER TABLE topics ADD COLUMN IF NOT EXISTS image_key TEXT;
+
+ALTER TABLE media ADD COLUMN IF NOT EXISTS blob_key TEXT;
+ALTER TABLE media ALTER COLUMN data DROP NOT NULL;
@@ -0,0 +1,17 @@
+-- Responses to writes sent with an Idempotency-Key header, replayed when a
+-- client retries the same request. Keys are scoped to the user that sent
+-- them. status stays NULL while the first request is still being handled;
+-- a retry takes such a claim over once its lease has run out.
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id INT NOT NULL,
+    key TEXT NOT NULL,
+    request_hash TEXT NOT NULL,
+    status INT,
+    headers JSONB,
+    body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_at_idx ON idempotency_keys (created_at);
@@ -0,0 +1,6 @@
+-- Revision counters for optimistic concurrency. Every edit bumps version,
+-- and edits that name an older version are rejected.
+
+ALTER TABLE topics ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
@@ -0,0 +1,13 @@
+-- Token buckets of the postgres rate limit backend, keyed by policy name
+-- and user or client IP. full_at is when a bucket will have refilled, after
+-- which the row can be deleted.
+
+CREATE TABLE IF NOT EXISTS rate_limits (
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    allowed BOOLEAN NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    full_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rate_limits_full_at_idx ON rate_limits (full_at);
@@ -0,0 +1,7 @@
+-- Accounts whose owners asked for them to be deleted. The API deletes them
+-- once the grace period has passed, unless the request is cancelled first.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_requested_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS users_deletion_requested_at_idx ON users (deletion_requested_at)
+    WHERE deletion_requested_at IS NOT NULL;
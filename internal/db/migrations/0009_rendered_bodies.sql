@@ -0,0 +1,6 @@
+-- The HTML rendered from the markdown of posts and comments, written along
+-- with it so reads need not render it again. Rows written before stay NULL
+-- and are rendered when read.
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS body_html TEXT;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS body_html TEXT;
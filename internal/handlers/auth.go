@@ -4,66 +4,79 @@ import (
 	"database/sql"
 	"encoding/json"
 	"net/http"
-	"regexp"
+	"strconv"
 
 	"backend/internal/auth"
-	"backend/internal/db"
+	"backend/internal/metrics"
+	"backend/internal/problem"
 )
 
-type LoginRequest struct {
-	Username string `json:"username"`
-}
+func Login(db *sql.DB, tokens *auth.Tokens) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req LoginRequest
+		if err := decodeJSON(w, r, &req, maxJSONBody); err != nil {
+			problem.Write(w, r, err)
+			return
+		}
 
-func Login(w http.ResponseWriter, r *http.Request) {
-	var req LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Bad Request", http.StatusBadRequest)
-		return
-	}
+		if err := req.Validate(); err != nil {
+			problem.Write(w, r, err)
+			return
+		}
 
-	if len(req.Username) > 20 {
-		http.Error(w, "Username is too long.", http.StatusBadRequest)
-		return
-	} else if !regexp.MustCompile(`^\d*[a-zA-Z][a-zA-Z0-9]*$`).MatchString(req.Username) {
-		http.Error(w, "Username must be alphanumeric, and must have at least one alphabetical character.", http.StatusBadRequest)
-		return
-	}
+		var (
+			userID    int
+			banned    bool
+			banReason string
+		)
+		newUser := false
+
+		err := db.QueryRowContext(r.Context(),
+			"SELECT id, banned_at IS NOT NULL, ban_reason FROM users WHERE username = $1",
+			req.Username,
+		).Scan(&userID, &banned, &banReason)
 
-	var userID int
+		if err == sql.ErrNoRows {
+			_, insertErr := db.ExecContext(r.Context(),
+				"INSERT INTO users (username) VALUES ($1)",
+				req.Username,
+			)
 
-	err := db.Conn.QueryRow(
-		"SELECT id FROM users WHERE username = $1",
-		req.Username,
-	).Scan(&userID)
+			if insertErr != nil {
+				problem.Write(w, r, problem.Internal(insertErr))
+				return
+			}
 
-	if err == sql.ErrNoRows {
-		_, insertErr := db.Conn.Exec(
-			"INSERT INTO users (username) VALUES ($1)",
-			req.Username,
-		)
+			db.QueryRowContext(r.Context(),
+				"SELECT id FROM users WHERE username = $1",
+				req.Username,
+			).Scan(&userID)
 
-		if insertErr != nil {
-			http.Error(w, "Failed to register. "+insertErr.Error(), http.StatusInternalServerError)
+			newUser = true
+		} else if err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		} else if banned {
+			problem.Write(w, r, auth.Banned(banReason))
 			return
 		}
 
-		db.Conn.QueryRow(
-			"SELECT id FROM users WHERE username = $1",
-			req.Username,
-		).Scan(&userID)
+		token, tokenErr := tokens.Generate(userID)
 
-	}
+		if tokenErr != nil {
+			problem.Write(w, r, problem.Internal(tokenErr))
+			return
+		}
 
-	token, tokenErr := auth.GenerateToken(userID)
+		metrics.Logins.WithLabelValues(strconv.FormatBool(newUser)).Inc()
 
-	if tokenErr != nil {
-		http.Error(w, "Token Error", http.StatusInternalServerError)
-		return
+		// Tokens must not be kept by caches, nor stored for Idempotency-Key
+		// replays.
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]string{
+			"token": token,
+		})
 	}
-
-	json.NewEncoder(w).Encode(map[string]string{
-		"token": token,
-	})
 }
 
 func Protected(w http.ResponseWriter, r *http.Request) {
@@ -2,165 +2,232 @@ package handlers
 
 import (
 	"backend/internal/auth"
-	"backend/internal/models"
+	"backend/internal/markdown"
+	"backend/internal/metrics"
+	"backend/internal/problem"
+	"backend/internal/store"
 	"database/sql"
 	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
 func GetCommentsByPost(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		postID := r.PathValue("id")
-
-		rows, err := db.Query(
-			`SELECT id, body, post, creator, created_at, is_edited, parent
-			 FROM comments
-			 WHERE post = $1
-			 ORDER BY created_at ASC`,
-			postID,
-		)
+		postID, err := strconv.Atoi(r.PathValue("id"))
 		if err != nil {
-			http.Error(w, "Query failed.", http.StatusInternalServerError)
+			problem.Write(w, r, errPostNotFound)
 			return
 		}
-		defer rows.Close()
 
-		comments := []models.Comment{}
-		for rows.Next() {
-			var c models.Comment
-			rows.Scan(&c.ID, &c.Body, &c.Post, &c.Creator, &c.CreatedAt, &c.IsEdited, &c.Parent)
-			comments = append(comments, c)
+		comments, err := store.CommentsByPost(r.Context(), db, postID)
+		if err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
 		}
 
 		json.NewEncoder(w).Encode(comments)
 	}
 }
 
-func AddComment(db *sql.DB) http.HandlerFunc {
+func GetComment(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		commentID, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			problem.Write(w, r, errCommentNotFound)
+			return
+		}
+
+		c, err := store.Comment(r.Context(), db, commentID)
+		if errors.Is(err, store.ErrNotFound) {
+			problem.Write(w, r, errCommentNotFound)
+			return
+		} else if err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+
+		w.Header().Set("ETag", etag(c.Version))
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+func AddComment(db *sql.DB, tokens *auth.Tokens) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		header := r.Header.Get("Authorization")
 		tokenStr := strings.TrimPrefix(header, "Bearer ")
-		userID, err := auth.VerifyToken(tokenStr)
+		userID, err := tokens.Verify(tokenStr)
 		if err != nil {
-			http.Error(w, "Invalid Token.", http.StatusUnauthorized)
+			problem.Write(w, r, errInvalidToken)
 			return
 		}
 
-		var c models.Comment
+		var c CommentRequest
+
+		if err := decodeJSON(w, r, &c, maxJSONBody); err != nil {
+			problem.Write(w, r, err)
+			return
+		}
 
-		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
-			http.Error(w, "Invalid JSON.", http.StatusBadRequest)
-			log.Println("Error decoding JSON:", err)
+		var ok bool
+		if c.Post, ok = pathID(r, c.Post); !ok {
+			problem.Write(w, r, errPostNotFound)
 			return
 		}
 
-		if len(c.Body) > 500 {
-			http.Error(w, "Comment body too long.", http.StatusBadRequest)
+		if err := c.Validate(); err != nil {
+			problem.Write(w, r, err)
 			return
 		}
 
 		if c.Parent != nil {
 			var parentPostID int
-			err = db.QueryRow(
-				`SELECT post FROM comments WHERE id = $1`,
+			err = db.QueryRowContext(r.Context(),
+				`SELECT post FROM comments WHERE id = $1 AND deleted_at IS NULL`,
 				*c.Parent,
 			).Scan(&parentPostID)
 			if err != nil {
-				http.Error(w, "Parent comment not found.", http.StatusBadRequest)
+				problem.Write(w, r, problem.Invalid("parent", "Parent comment not found."))
 				return
 			}
 			if parentPostID != c.Post {
-				http.Error(w, "Parent comment does not belong to the same post.", http.StatusBadRequest)
+				problem.Write(w, r, problem.Invalid("parent", "Parent comment does not belong to the same post."))
 				return
 			}
 		}
-		_, err = db.Exec(
-			`INSERT INTO comments (post, creator, body, parent) VALUES ($1, $2, $3, $4)`,
+		comment, err := store.ScanComment(db.QueryRowContext(r.Context(),
+			`INSERT INTO comments (post, creator, body, parent, body_html)
+			SELECT $1::int, $2::int, $3::text, $4::int, $5::text WHERE EXISTS (SELECT 1 FROM posts WHERE id = $1 AND deleted_at IS NULL)
+			RETURNING `+store.CommentColumns,
 			c.Post,
 			userID,
 			c.Body,
 			c.Parent,
-		)
-		if err != nil {
-			log.Println("Database error:", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			markdown.Render(c.Body),
+		))
+		if errors.Is(err, sql.ErrNoRows) || isForeignKeyViolation(err) {
+			problem.Write(w, r, errPostNotFound)
+			return
+		} else if err != nil {
+			problem.Write(w, r, problem.Internal(err))
 			return
 		}
+		metrics.CommentsCreated.Inc()
 
-		w.WriteHeader(http.StatusAccepted)
+		w.Header().Set("Location", APIPrefix+"/comments/"+strconv.Itoa(comment.ID))
+		w.Header().Set("ETag", etag(comment.Version))
+		writeJSON(w, http.StatusCreated, comment)
 	})
 }
 
-func EditComment(db *sql.DB) http.HandlerFunc {
+func EditComment(db *sql.DB, tokens *auth.Tokens) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		header := r.Header.Get("Authorization")
 		tokenStr := strings.TrimPrefix(header, "Bearer ")
-		userID, err := auth.VerifyToken(tokenStr)
+		userID, err := tokens.Verify(tokenStr)
 		if err != nil {
-			http.Error(w, "Invalid Token.", http.StatusUnauthorized)
+			problem.Write(w, r, errInvalidToken)
 			return
 		}
 
-		var c models.Comment
+		var c CommentRequest
 
-		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
-			http.Error(w, "Invalid JSON.", http.StatusBadRequest)
-			log.Println("Error decoding JSON:", err)
+		if err := decodeJSON(w, r, &c, maxJSONBody); err != nil {
+			problem.Write(w, r, err)
 			return
 		}
 
-		if len(c.Body) > 500 {
-			http.Error(w, "Comment body too long.", http.StatusBadRequest)
+		var ok bool
+		if c.ID, ok = pathID(r, c.ID); !ok {
+			problem.Write(w, r, errCommentNotFound)
 			return
 		}
 
-		_, err = db.Exec(
-			`UPDATE comments SET body = $1, is_edited = TRUE WHERE id = $2 AND creator = $3`,
+		if err := c.Validate(); err != nil {
+			problem.Write(w, r, err)
+			return
+		}
+
+		versions, err := expectedVersions(r, c.Version)
+		if err != nil {
+			problem.Write(w, r, err)
+			return
+		}
+
+		comment, err := store.ScanComment(db.QueryRowContext(r.Context(),
+			`UPDATE comments SET body = $1, body_html = $5, is_edited = TRUE, version = version + 1
+			WHERE id = $2 AND creator = $3 AND deleted_at IS NULL
+				AND ($4::bigint[] IS NULL OR version = ANY($4))
+			RETURNING `+store.CommentColumns,
 			c.Body,
 			c.ID,
 			userID,
-		)
-		if err != nil {
-			log.Println("Database error:", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			versions,
+			markdown.Render(c.Body),
+		))
+		if errors.Is(err, sql.ErrNoRows) {
+			problem.Write(w, r, unmatchedError(r.Context(), db, "comments", c.ID, userID, errCommentNotFound))
+			return
+		} else if err != nil {
+			problem.Write(w, r, problem.Internal(err))
 			return
 		}
 
-		w.WriteHeader(http.StatusAccepted)
+		w.Header().Set("ETag", etag(comment.Version))
+		writeJSON(w, http.StatusOK, comment)
 	})
 }
 
-func DeleteComment(db *sql.DB) http.HandlerFunc {
+func DeleteComment(db *sql.DB, tokens *auth.Tokens) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		header := r.Header.Get("Authorization")
 		tokenStr := strings.TrimPrefix(header, "Bearer ")
-		userID, err := auth.VerifyToken(tokenStr)
+		userID, err := tokens.Verify(tokenStr)
 		if err != nil {
-			http.Error(w, "Invalid Token.", http.StatusUnauthorized)
+			problem.Write(w, r, errInvalidToken)
 			return
 		}
 
-		var c models.Comment
+		var c CommentRequest
 
-		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
-			http.Error(w, "Invalid JSON.", http.StatusBadRequest)
-			log.Println("Error decoding JSON:", err)
+		if r.PathValue("id") == "" {
+			if err := decodeJSON(w, r, &c, maxJSONBody); err != nil {
+				problem.Write(w, r, err)
+				return
+			}
+		}
+
+		var ok bool
+		if c.ID, ok = pathID(r, c.ID); !ok {
+			problem.Write(w, r, errCommentNotFound)
 			return
 		}
 
-		_, err = db.Exec(
-			`DELETE FROM comments WHERE id = $1 AND creator = $2`,
+		res, err := db.ExecContext(r.Context(),
+			`DELETE FROM comments WHERE id = $1 AND creator = $2 AND deleted_at IS NULL`,
 			c.ID,
 			userID,
 		)
 		if err != nil {
-			log.Println("Database error:", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.Write(w, r, problem.Internal(err))
 			return
 		}
 
-		w.WriteHeader(http.StatusAccepted)
+		if !matched(res) {
+			err := unmatchedError(r.Context(), db, "comments", c.ID, userID, errCommentNotFound)
+			if err == errNotOwner {
+				err = removeAsModerator(r.Context(), db, userID, func() error {
+					return store.SetCommentDeleted(r.Context(), db, c.ID, true)
+				})
+			}
+			if err != nil {
+				problem.Write(w, r, err)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
 	})
 }
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"backend/internal/logging"
+	"backend/internal/problem"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxJSONBody caps requests that carry only text. The longest field,
+	// a post body, is 3000 characters.
+	maxJSONBody = 64 << 10
+	// maxImageJSONBody leaves room for a base64 encoded image of
+	// maxImageSize next to the text fields.
+	maxImageJSONBody = (maxImageSize+2)/3*4 + maxJSONBody
+)
+
+var (
+	errUnsupportedMediaType = problem.New(http.StatusUnsupportedMediaType, "unsupported_media_type",
+		"Request body must be JSON, sent with Content-Type: application/json.")
+	errTrailingData = errors.New("data after the JSON value")
+)
+
+// decodeJSON reads the JSON request body into v, reading at most maxBytes
+// of it. The body must be a single JSON value with no unknown fields.
+// Errors are problems that can be sent to the client as they are.
+//
+// Legacy routes predate these checks, so their clients may leave out the
+// Content-Type header and send fields the API ignores.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any, maxBytes int64) error {
+	legacy := isLegacy(r)
+	if !legacy {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			return errUnsupportedMediaType
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	dec := json.NewDecoder(r.Body)
+	if !legacy {
+		dec.DisallowUnknownFields()
+	}
+
+	err := dec.Decode(v)
+	if err == nil {
+		if _, err = dec.Token(); err == io.EOF {
+			return nil
+		} else if err == nil {
+			err = errTrailingData
+		}
+	}
+	logging.FromContext(r.Context()).Debug("Invalid JSON", "err", err)
+
+	var (
+		tooLarge    *http.MaxBytesError
+		syntaxError *json.SyntaxError
+		typeError   *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &tooLarge):
+		return problem.New(http.StatusRequestEntityTooLarge, "body_too_large",
+			"Request body must be at most "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes.")
+	case errors.Is(err, io.EOF):
+		return problem.New(http.StatusBadRequest, "invalid_json", "Request body is empty.")
+	case errors.As(err, &syntaxError):
+		return problem.New(http.StatusBadRequest, "invalid_json",
+			"Invalid JSON at byte "+strconv.FormatInt(syntaxError.Offset, 10)+".")
+	case errors.As(err, &typeError) && typeError.Field != "":
+		return problem.Invalid(typeError.Field, "Must be "+jsonType(typeError.Type)+".")
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return problem.Invalid(field, "Unknown field.")
+	case errors.Is(err, errTrailingData):
+		return problem.New(http.StatusBadRequest, "invalid_json", "Request body must hold a single JSON value.")
+	default:
+		return errInvalidJSON
+	}
+}
+
+// jsonType describes the JSON values that decode into t.
+func jsonType(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
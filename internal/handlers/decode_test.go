@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"backend/internal/problem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	type payload struct {
+		Title string `json:"title"`
+		Count int    `json:"count"`
+	}
+	const v1, legacy = "POST " + APIPrefix + "/posts", "/addpost"
+
+	tests := []struct {
+		name        string
+		pattern     string
+		contentType string
+		body        string
+		maxBytes    int64
+		want        *problem.Error // nil when the body decodes
+	}{
+		{"valid", v1, "application/json", `{"title":"hi","count":2}`, 64, nil},
+		{"media type parameters", v1, "application/json; charset=utf-8", `{"title":"hi"}`, 64, nil},
+		{"missing content type", v1, "", `{"title":"hi"}`, 64, errUnsupportedMediaType},
+		{"form content type", v1, "application/x-www-form-urlencoded", `{"title":"hi"}`, 64, errUnsupportedMediaType},
+		{"text content type", v1, "text/plain", `{"title":"hi"}`, 64, errUnsupportedMediaType},
+		{"unknown field", v1, "application/json", `{"title":"hi","author":1}`, 64,
+			problem.Invalid("author", "Unknown field.")},
+		{"too large", v1, "application/json", `{"title":"` + strings.Repeat("a", 64) + `"}`, 64,
+			problem.New(http.StatusRequestEntityTooLarge, "body_too_large", "Request body must be at most 64 bytes.")},
+		{"at the limit", v1, "application/json", `{"title":"` + strings.Repeat("a", 52) + `"}`, 64, nil},
+		{"empty", v1, "application/json", ``, 64,
+			problem.New(http.StatusBadRequest, "invalid_json", "Request body is empty.")},
+		{"syntax error", v1, "application/json", `{"title":}`, 64,
+			problem.New(http.StatusBadRequest, "invalid_json", "Invalid JSON at byte 10.")},
+		{"wrong type", v1, "application/json", `{"count":"two"}`, 64,
+			problem.Invalid("count", "Must be an integer.")},
+		{"trailing data", v1, "application/json", `{"title":"hi"} {}`, 64,
+			problem.New(http.StatusBadRequest, "invalid_json", "Request body must hold a single JSON value.")},
+		{"legacy without content type", legacy, "", `{"title":"hi"}`, 64, nil},
+		{"legacy unknown field", legacy, "text/plain", `{"title":"hi","author":1}`, 64, nil},
+		{"legacy too large", legacy, "", `{"title":"` + strings.Repeat("a", 64) + `"}`, 64,
+			problem.New(http.StatusRequestEntityTooLarge, "body_too_large", "Request body must be at most 64 bytes.")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			r.Pattern = tt.pattern
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			var p payload
+			err := decodeJSON(httptest.NewRecorder(), r, &p, tt.maxBytes)
+
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("decodeJSON: %v", err)
+				}
+				if p.Title == "" {
+					t.Errorf("decodeJSON left the payload empty")
+				}
+				return
+			}
+			var got *problem.Error
+			if !errors.As(err, &got) {
+				t.Fatalf("decodeJSON = %v, want a problem", err)
+			}
+			if got.Status != tt.want.Status || got.Code != tt.want.Code || got.Detail != tt.want.Detail ||
+				!slices.Equal(got.Fields, tt.want.Fields) {
+				t.Errorf("decodeJSON = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"backend/internal/blob"
+	"backend/internal/buildinfo"
+	"backend/internal/db"
+	"backend/internal/logging"
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// readyTimeout bounds each readiness check, so a hung dependency fails the
+// probe instead of stalling it.
+const readyTimeout = 2 * time.Second
+
+// Healthz reports that the process is up and serving. It checks nothing
+// else, so an orchestrator does not restart the API over a database outage.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readyz reports whether the API can serve traffic: the database answers,
+// its schema is up to date and the blob store is reachable.
+func Readyz(conn *sql.DB, blobs blob.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checks := map[string]string{}
+		ready := true
+		check := func(name string, fn func(ctx context.Context) (string, error)) {
+			ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+			defer cancel()
+			status, err := fn(ctx)
+			if err != nil {
+				logging.FromContext(ctx).Warn("Readiness check failed", "check", name, "err", err)
+				status = "unavailable"
+			}
+			if status != "ok" {
+				ready = false
+			}
+			checks[name] = status
+		}
+
+		check("database", func(ctx context.Context) (string, error) {
+			return "ok", conn.PingContext(ctx)
+		})
+		check("migrations", func(ctx context.Context) (string, error) {
+			pending, err := db.Pending(ctx, conn)
+			if err != nil || len(pending) == 0 {
+				return "ok", err
+			}
+			return strconv.Itoa(len(pending)) + " pending", nil
+		})
+		check("blobs", func(ctx context.Context) (string, error) {
+			return "ok", blobs.Ping(ctx)
+		})
+
+		status, code := "ok", http.StatusOK
+		if !ready {
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, code, map[string]any{"status": status, "checks": checks})
+	}
+}
+
+// Version describes the running build.
+func Version(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, buildinfo.Read())
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"backend/internal/problem"
+	"backend/internal/store"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// APIPrefix is where the current version of the API is mounted.
+const APIPrefix = store.APIPrefix
+
+var (
+	errInvalidJSON     = problem.New(http.StatusBadRequest, "invalid_json", "Invalid JSON.")
+	errInvalidToken    = problem.New(http.StatusUnauthorized, "invalid_token", "Invalid token.")
+	errPostNotFound    = problem.New(http.StatusNotFound, "post_not_found", "Post not found.")
+	errCommentNotFound = problem.New(http.StatusNotFound, "comment_not_found", "Comment not found.")
+	errTopicNotFound   = problem.New(http.StatusNotFound, "topic_not_found", "Topic not found.")
+	errUserNotFound    = problem.New(http.StatusNotFound, "user_not_found", "User not found.")
+	errImageNotFound   = problem.New(http.StatusNotFound, "image_not_found", "Image not found.")
+	errNotOwner        = problem.New(http.StatusForbidden, "not_owner", "Only the creator can change this.")
+	errNoDeletion      = problem.New(http.StatusNotFound, "deletion_not_requested", "No account deletion is pending.")
+)
+
+// pathID returns the numeric {id} of an /api/v1 route. Legacy routes carry
+// the ID in the request body instead, in which case bodyID is returned.
+func pathID(r *http.Request, bodyID int) (int, bool) {
+	s := r.PathValue("id")
+	if s == "" {
+		return bodyID, true
+	}
+	id, err := strconv.Atoi(s)
+	return id, err == nil
+}
+
+// matched reports whether a write statement touched any row.
+func matched(res sql.Result) bool {
+	n, err := res.RowsAffected()
+	return err == nil && n > 0
+}
+
+// unmatchedError explains why a write limited to the creator of a post or
+// comment touched no rows: the row is gone or deleted, it belongs to
+// someone else, or it was edited since the client read it.
+func unmatchedError(ctx context.Context, q store.Querier, table string, id, userID int, notFound error) error {
+	var creator int
+	err := q.QueryRowContext(ctx, `SELECT creator FROM `+table+` WHERE id = $1 AND deleted_at IS NULL`, id).Scan(&creator)
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFound
+	} else if err != nil {
+		return problem.Internal(err)
+	}
+	if creator != userID {
+		return errNotOwner
+	}
+	return errPreconditionFailed
+}
+
+// removeAsModerator runs remove, which soft-deletes a post or comment of
+// another user, if userID is a moderator. It returns errNotOwner for anyone
+// else.
+func removeAsModerator(ctx context.Context, q store.Querier, userID int, remove func() error) error {
+	ok, err := store.HasRole(ctx, q, userID, store.RoleModerator, store.RoleAdmin)
+	if err != nil {
+		return problem.Internal(err)
+	} else if !ok {
+		return errNotOwner
+	}
+	if err := remove(); err != nil {
+		return problem.Internal(err)
+	}
+	return nil
+}
+
+// writeJSON sends v as the response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"backend/internal/blob"
+	"backend/internal/images"
+	"backend/internal/logging"
+	"backend/internal/problem"
+	"backend/internal/store"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const maxImageSize = 2 << 20
+
+// decodeImage turns a base64 upload into sanitized image bytes. Errors are
+// safe to show to the client.
+func decodeImage(encoded string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("Invalid base64 image.")
+	}
+	if len(decoded) > maxImageSize {
+		return nil, errors.New("Image too large.")
+	}
+	return images.Sanitize(decoded)
+}
+
+// storeImage saves an uploaded image in the blob store and returns its key.
+func storeImage(ctx context.Context, blobs blob.Store, prefix string, data []byte) (string, error) {
+	key := blob.Key(prefix, data)
+	if err := blobs.Put(ctx, key, data); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// loadImage returns the bytes of an image stored under key, or the legacy
+// bytea column for rows that have not been migrated to the blob store yet.
+// Legacy images predate upload checks, so they are sanitized as uploads
+// are; those that fail are reported as missing.
+func loadImage(ctx context.Context, blobs blob.Store, key sql.NullString, legacy []byte) ([]byte, error) {
+	if !key.Valid {
+		if legacy == nil {
+			return nil, blob.ErrNotFound
+		}
+		image, err := images.Sanitize(legacy)
+		if err != nil {
+			logging.FromContext(ctx).Warn("Legacy image failed sanitizing", "err", err)
+			return nil, blob.ErrNotFound
+		}
+		return image, nil
+	}
+	return blobs.Get(ctx, key.String)
+}
+
+// DiscardImage removes an image and its thumbnails once nothing references
+// them. Failing to do so only leaks storage, so errors are logged rather
+// than returned.
+func DiscardImage(ctx context.Context, blobs blob.Store, key sql.NullString) {
+	if !key.Valid {
+		return
+	}
+	keys := []string{key.String}
+	for _, size := range images.Sizes {
+		keys = append(keys, thumbnailKey(key.String, size))
+	}
+	for _, k := range keys {
+		if err := blobs.Delete(ctx, k); err != nil {
+			logging.FromContext(ctx).Warn("Failed to delete blob", "key", k, "err", err)
+		}
+	}
+}
+
+func thumbnailKey(key string, size int) string {
+	return key + "@" + strconv.Itoa(size)
+}
+
+// Cache-Control policies for serveImage. Images may be cached for a year
+// when their URL changes with their content; others must be revalidated,
+// which costs a 304 rather than the whole image when the ETag still
+// matches. Images only some users may see are kept out of shared caches.
+const (
+	cacheImmutable  = "public, max-age=31536000, immutable"
+	cacheRevalidate = "public, no-cache"
+	cachePrivate    = "private, no-cache"
+)
+
+// versionCache returns cacheImmutable when the request URL carries the
+// version of the image that is about to be served, and cacheRevalidate
+// otherwise.
+func versionCache(r *http.Request, imageEpoch float64) string {
+	if r.URL.Query().Get("v") == store.ImageVersion(imageEpoch) {
+		return cacheImmutable
+	}
+	return cacheRevalidate
+}
+
+// imageETag derives a strong ETag from the content hash, which is already
+// part of blob keys and only has to be computed for legacy bytea images.
+func imageETag(key sql.NullString, legacy []byte, size int) string {
+	var hash string
+	if key.Valid {
+		hash = path.Base(key.String)
+	} else {
+		sum := sha256.Sum256(legacy)
+		hash = hex.EncodeToString(sum[:])
+	}
+	if size != 0 {
+		hash += "-" + strconv.Itoa(size)
+	}
+	return `"` + hash + `"`
+}
+
+// serveImage writes an image, or the thumbnail picked by ?size=, with the
+// content type sniffed from its bytes. Thumbnails are generated on first
+// request and kept in the blob store next to the original. cacheControl is
+// one of the cache policies above.
+func serveImage(w http.ResponseWriter, r *http.Request, blobs blob.Store, key sql.NullString, legacy []byte, cacheControl string) {
+	size := 0
+	if s := r.URL.Query().Get("size"); s != "" {
+		size, _ = strconv.Atoi(s)
+		if !slices.Contains(images.Sizes, size) {
+			problem.Write(w, r, problem.Invalid("size", "Size must be one of 64, 128 or 512."))
+			return
+		}
+	}
+
+	if !key.Valid && legacy == nil {
+		problem.Write(w, r, errImageNotFound)
+		return
+	}
+
+	etag := imageETag(key, legacy, size)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", cacheControl)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	ctx := r.Context()
+	var (
+		image []byte
+		err   error
+	)
+	if size != 0 && key.Valid {
+		image, err = blobs.Get(ctx, thumbnailKey(key.String, size))
+	}
+	if image == nil {
+		image, err = loadImage(ctx, blobs, key, legacy)
+		if err == nil && size != 0 {
+			image, err = thumbnail(ctx, blobs, key, image, size)
+		}
+	}
+
+	if err != nil {
+		if !errors.Is(err, blob.ErrNotFound) {
+			logging.FromContext(ctx).Error("Failed to load image", "err", err)
+		}
+		w.Header().Del("ETag")
+		w.Header().Del("Cache-Control")
+		problem.Write(w, r, errImageNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(image))
+	w.Write(image)
+}
+
+// etagMatches implements the weak comparison If-None-Match asks for.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func thumbnail(ctx context.Context, blobs blob.Store, key sql.NullString, image []byte, size int) ([]byte, error) {
+	thumb, err := images.Thumbnail(image, size)
+	if err != nil {
+		return nil, err
+	}
+	if key.Valid {
+		if err := blobs.Put(ctx, thumbnailKey(key.String, size), thumb); err != nil {
+			logging.FromContext(ctx).Warn("Failed to cache thumbnail", "err", err)
+		}
+	}
+	return thumb, nil
+}
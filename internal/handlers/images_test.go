@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"backend/internal/blob"
+	"backend/internal/store"
+	"bytes"
+	"context"
+	"database/sql"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func testPNG(t *testing.T, size int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, size, size))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestServeImage(t *testing.T) {
+	ctx := context.Background()
+	blobs := blob.NewMemory()
+	data := testPNG(t, 256)
+	key, err := storeImage(ctx, blobs, "media/1", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := sql.NullString{String: key, Valid: true}
+
+	tests := []struct {
+		name        string
+		key         sql.NullString
+		legacy      []byte
+		query       string
+		ifNoneMatch string
+		cache       string // cacheImmutable if empty
+		status      int
+		width       int
+	}{
+		{name: "original", key: stored, status: http.StatusOK, width: 256},
+		{name: "private", key: stored, cache: cachePrivate, status: http.StatusOK, width: 256},
+		{name: "thumbnail", key: stored, query: "?size=64", status: http.StatusOK, width: 64},
+		{name: "unsupported size", key: stored, query: "?size=7", status: http.StatusBadRequest},
+		{name: "not modified", key: stored, ifNoneMatch: imageETag(stored, nil, 0), status: http.StatusNotModified},
+		{name: "missing blob", key: sql.NullString{String: "media/1/missing", Valid: true}, status: http.StatusNotFound},
+		{name: "no image", status: http.StatusNotFound},
+		{name: "legacy", legacy: testPNG(t, 32), status: http.StatusOK, width: 32},
+		{name: "legacy not an image", legacy: []byte("<script>alert(1)</script>"), status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/media/1"+tt.query, nil)
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			cache := tt.cache
+			if cache == "" {
+				cache = cacheImmutable
+			}
+			w := httptest.NewRecorder()
+			serveImage(w, r, blobs, tt.key, tt.legacy, cache)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			// Errors must not be cached as if they were the image.
+			if w.Code != http.StatusOK && w.Code != http.StatusNotModified {
+				cache = ""
+			}
+			if got := w.Header().Get("Cache-Control"); got != cache {
+				t.Errorf("Cache-Control = %q, want %q", got, cache)
+			}
+			if tt.width == 0 {
+				return
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+				t.Errorf("Content-Type = %q, want image/png", ct)
+			}
+			cfg, err := png.DecodeConfig(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Width != tt.width {
+				t.Errorf("width = %d, want %d", cfg.Width, tt.width)
+			}
+		})
+	}
+
+	if !slices.Contains(blobs.Keys(), thumbnailKey(key, 64)) {
+		t.Errorf("thumbnail was not kept in the blob store, keys: %v", blobs.Keys())
+	}
+}
+
+func TestVersionCache(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"?v=" + store.ImageVersion(1700000000.5), cacheImmutable},
+		{"?v=" + store.ImageVersion(1600000000), cacheRevalidate},
+		{"", cacheRevalidate},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/users/alice/image"+tt.query, nil)
+		if got := versionCache(r, 1700000000.5); got != tt.want {
+			t.Errorf("versionCache(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestDiscardImage(t *testing.T) {
+	ctx := context.Background()
+	blobs := blob.NewMemory()
+	key, err := storeImage(ctx, blobs, "users/1", testPNG(t, 600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := storeImage(ctx, blobs, "users/2", testPNG(t, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := sql.NullString{String: key, Valid: true}
+	for _, size := range []int{64, 512} {
+		if _, err := thumbnail(ctx, blobs, stored, testPNG(t, 600), size); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	DiscardImage(ctx, blobs, stored)
+	DiscardImage(ctx, blobs, sql.NullString{})
+
+	if keys := blobs.Keys(); !slices.Equal(keys, []string{other}) {
+		t.Errorf("keys left = %v, want only %s", keys, other)
+	}
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"backend/internal/auth"
+	"backend/internal/blob"
+	"backend/internal/models"
+	"backend/internal/problem"
+	"backend/internal/store"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxAttachments = 4
+	maxAltLength   = 300
+)
+
+var errMediaNotFound = errors.New("attachment not found")
+
+func UploadMedia(db *sql.DB, tokens *auth.Tokens, blobs blob.Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		tokenStr := strings.TrimPrefix(header, "Bearer ")
+		userID, err := tokens.Verify(tokenStr)
+		if err != nil {
+			problem.Write(w, r, errInvalidToken)
+			return
+		}
+
+		var t ImageRequest
+
+		if err := decodeJSON(w, r, &t, maxImageJSONBody); err != nil {
+			problem.Write(w, r, err)
+			return
+		}
+
+		if err := t.Validate(); err != nil {
+			problem.Write(w, r, err)
+			return
+		}
+		decoded, err := decodeImage(t.ImageBase64)
+		if err != nil {
+			problem.Write(w, r, problem.Invalid("image", err.Error()))
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+		defer tx.Rollback()
+
+		// PruneMedia may be deciding whether to delete this very blob.
+		key := blob.Key("media/"+strconv.Itoa(userID), decoded)
+		if err := store.LockBlob(r.Context(), tx, key); err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+		if err := blobs.Put(r.Context(), key, decoded); err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+
+		var a models.Attachment
+		err = tx.QueryRowContext(r.Context(),
+			`INSERT INTO media (uploader, blob_key) VALUES ($1, $2) RETURNING id`,
+			userID,
+			key,
+		).Scan(&a.ID)
+		if err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+		a.URL = store.MediaURL(a.ID)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", a.URL)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(a)
+	})
+}
+
+// GetMedia serves the images attached to posts to everyone, and uploads
+// not yet attached to a post only to the user who uploaded them.
+func GetMedia(db *sql.DB, tokens *auth.Tokens, blobs blob.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		header := r.Header.Get("Authorization")
+		tokenStr := strings.TrimPrefix(header, "Bearer ")
+		var userID int
+		if uid, err := tokens.Verify(tokenStr); err == nil {
+			userID = uid
+		}
+
+		var (
+			legacy   []byte
+			key      sql.NullString
+			attached bool
+		)
+		// Attachments of deleted posts are hidden along with the post.
+		err := db.QueryRowContext(r.Context(),
+			`SELECT data, blob_key, post IS NOT NULL FROM media
+			WHERE id = $1 AND (post IN (SELECT id FROM posts WHERE deleted_at IS NULL)
+				OR (post IS NULL AND uploader = $2))`,
+			id,
+			userID,
+		).Scan(&legacy, &key, &attached)
+		if err != nil {
+			problem.Write(w, r, errImageNotFound)
+			return
+		}
+
+		// An upload's content never changes, so its URL is already
+		// versioned, but an unattached one is its uploader's alone.
+		cache := cacheImmutable
+		if !attached {
+			cache = cachePrivate
+		}
+		serveImage(w, r, blobs, key, legacy, cache)
+	}
+}
+
+// PruneMedia deletes uploads that are not attached to any post and are
+// older than maxAge, either because they were never used or because their
+// post was edited or deleted.
+func PruneMedia(db *sql.DB, blobs blob.Store, maxAge time.Duration) (int64, error) {
+	rows, err := db.Query(
+		`DELETE FROM media WHERE post IS NULL AND created_at < now() - make_interval(secs => $1)
+		RETURNING blob_key`,
+		maxAge.Seconds(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var (
+		n    int64
+		keys []sql.NullString
+	)
+	for rows.Next() {
+		var key sql.NullString
+		if err := rows.Scan(&key); err != nil {
+			return n, err
+		}
+		keys = append(keys, key)
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+
+	for _, key := range keys {
+		if err := discardMediaBlob(context.Background(), db, blobs, key); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// discardMediaBlob deletes the blob of a deleted upload unless other
+// uploads still use it. Keys are content-addressed per uploader, so the
+// same file uploaded twice shares a blob that must survive until its last
+// row is gone, including rows that uploads running now are about to add.
+func discardMediaBlob(ctx context.Context, db *sql.DB, blobs blob.Store, key sql.NullString) error {
+	if !key.Valid {
+		return nil
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := store.LockBlob(ctx, tx, key.String); err != nil {
+		return err
+	}
+	used, err := store.MediaBlobInUse(ctx, tx, key.String)
+	if err != nil {
+		return err
+	}
+	if !used {
+		DiscardImage(ctx, blobs, key)
+	}
+	return tx.Commit()
+}
+
+// attachMedia makes attachments, in order, the complete set of images on a
+// post. Uploads must belong to userID and must not be used by another post.
+func attachMedia(ctx context.Context, tx *sql.Tx, postID, userID int, attachments []models.Attachment) error {
+	_, err := tx.ExecContext(ctx, `UPDATE media SET post = NULL WHERE post = $1`, postID)
+	if err != nil {
+		return err
+	}
+
+	for i, a := range attachments {
+		res, err := tx.ExecContext(ctx,
+			`UPDATE media SET post = $1, alt = $2, position = $3
+			WHERE id = $4 AND uploader = $5 AND post IS NULL`,
+			postID,
+			a.Alt,
+			i,
+			a.ID,
+			userID,
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return fmt.Errorf("%w: %d", errMediaNotFound, a.ID)
+		}
+	}
+
+	return nil
+}
+
+func writeAttachError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errMediaNotFound) {
+		problem.Write(w, r, problem.Invalid("attachments", "Attachment not found."))
+		return
+	}
+	problem.Write(w, r, problem.Internal(err))
+}
@@ -2,298 +2,344 @@ package handlers
 
 import (
 	"backend/internal/auth"
-	"backend/internal/models"
+	"backend/internal/markdown"
+	"backend/internal/metrics"
+	"backend/internal/problem"
+	"backend/internal/store"
 	"database/sql"
 	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
-func GetPostsByTopic(db *sql.DB) http.HandlerFunc {
+func GetPostsByTopic(db *sql.DB, tokens *auth.Tokens) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		topicName := r.PathValue("name")
 
 		header := r.Header.Get("Authorization")
 		tokenStr := strings.TrimPrefix(header, "Bearer ")
 		var userID int
-		if uid, err := auth.VerifyToken(tokenStr); err == nil {
+		if uid, err := tokens.Verify(tokenStr); err == nil {
 			userID = uid
 		}
 
-		rows, err := db.Query(`
-			SELECT
-				p.id,
-				p.title,
-				p.body,
-				p.topic,
-				p.creator,
-				p.created_at,
-				p.is_edited,
-				COALESCE(SUM(
-					CASE
-						WHEN pv.is_positive THEN 1
-						ELSE -1
-					END
-				), 0) AS score,
-				MAX(
-					CASE
-						WHEN pv.user_id = $2 AND pv.is_positive THEN 1
-						WHEN pv.user_id = $2 AND NOT pv.is_positive THEN -1
-					END
-				) AS user_vote
-			FROM posts p
-			LEFT JOIN post_votes pv ON pv.post_id = p.id
-			WHERE p.topic = $1
-			GROUP BY
-				p.id, p.title, p.body, p.topic,
-				p.creator, p.created_at, p.is_edited
-			ORDER BY score DESC
-		`,
-			topicName,
-			userID,
-		)
+		posts, err := store.PostsByTopic(r.Context(), db, topicName, userID)
 		if err != nil {
-			http.Error(w, "Query failed.", http.StatusInternalServerError)
+			problem.Write(w, r, problem.Internal(err))
 			return
 		}
-		defer rows.Close()
-
-		posts := []models.Post{}
-		for rows.Next() {
-			var p models.Post
-			var userVote sql.NullInt64
-			rows.Scan(&p.ID, &p.Title, &p.Body, &p.Topic, &p.Creator, &p.CreatedAt, &p.IsEdited, &p.Score, &userVote)
-			if userVote.Valid {
-				p.UserVote = int(userVote.Int64)
-			}
-			posts = append(posts, p)
-		}
 
 		json.NewEncoder(w).Encode(posts)
 	}
 }
 
-func GetPost(db *sql.DB) http.HandlerFunc {
+func GetPost(db *sql.DB, tokens *auth.Tokens) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		postID := r.PathValue("id")
+		postID, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			problem.Write(w, r, errPostNotFound)
+			return
+		}
 
 		header := r.Header.Get("Authorization")
 		tokenStr := strings.TrimPrefix(header, "Bearer ")
 		var userID int
-		if uid, err := auth.VerifyToken(tokenStr); err == nil {
+		if uid, err := tokens.Verify(tokenStr); err == nil {
 			userID = uid
 		}
 
-		row := db.QueryRow(
-			`SELECT 
-				id, 
-				title, 
-				body, 
-				topic, 
-				creator, 
-				created_at, 
-				is_edited,
-				COALESCE(
-					(SELECT SUM(CASE WHEN is_positive THEN 1 ELSE -1 END)
-					FROM post_votes
-					WHERE post_id = post.id),
-				0) AS score,
-				(SELECT 
-					CASE 
-						WHEN is_positive IS TRUE THEN 1 
-						WHEN is_positive IS FALSE THEN -1 
-						ELSE NULL 
-					END 
-				FROM post_votes WHERE post_id = post.id AND user_id = $2 LIMIT 1) AS user_vote
-			 FROM posts post WHERE id = $1`,
-			postID,
-			userID,
-		)
-
-		var p models.Post
-		var userVote sql.NullInt64
-		if err := row.Scan(&p.ID, &p.Title, &p.Body, &p.Topic, &p.Creator, &p.CreatedAt, &p.IsEdited, &p.Score, &userVote); err != nil {
-			http.Error(w, "Post not found.", http.StatusNotFound)
+		p, err := store.Post(r.Context(), db, postID, userID)
+		if errors.Is(err, store.ErrNotFound) {
+			problem.Write(w, r, errPostNotFound)
+			return
+		} else if err != nil {
+			problem.Write(w, r, problem.Internal(err))
 			return
-		}
-		if userVote.Valid {
-			p.UserVote = int(userVote.Int64)
 		}
 
+		w.Header().Set("ETag", etag(p.Version))
 		json.NewEncoder(w).Encode(p)
 	}
 }
 
-func VotePost(db *sql.DB) http.HandlerFunc {
+func VotePost(db *sql.DB, tokens *auth.Tokens) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		header := r.Header.Get("Authorization")
 		tokenStr := strings.TrimPrefix(header, "Bearer ")
-		userID, err := auth.VerifyToken(tokenStr)
+		userID, err := tokens.Verify(tokenStr)
 		if err != nil {
-			http.Error(w, "Invalid Token.", http.StatusUnauthorized)
+			problem.Write(w, r, errInvalidToken)
 			return
 		}
 
-		var payload struct {
-			PostID     int   `json:"post_id"`
-			IsPositive *bool `json:"is_positive"`
+		var payload VoteRequest
+
+		// DELETE /api/v1/posts/{id}/vote has no body and clears the vote.
+		if r.Method != http.MethodDelete || r.PathValue("id") == "" {
+			if err := decodeJSON(w, r, &payload, maxJSONBody); err != nil {
+				problem.Write(w, r, err)
+				return
+			}
 		}
 
-		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-			http.Error(w, "Invalid JSON.", http.StatusBadRequest)
-			log.Println("Error decoding JSON:", err)
+		postID, ok := pathID(r, payload.PostID)
+		if !ok {
+			problem.Write(w, r, errPostNotFound)
 			return
 		}
 
 		if payload.IsPositive == nil {
-			_, err = db.Exec(`DELETE FROM post_votes WHERE post_id = $1 AND user_id = $2`, payload.PostID, userID)
+			_, err = db.ExecContext(r.Context(), `DELETE FROM post_votes WHERE post_id = $1 AND user_id = $2`, postID, userID)
 			if err != nil {
-				log.Println("Database error:", err)
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				problem.Write(w, r, problem.Internal(err))
 				return
 			}
+			metrics.Votes.WithLabelValues("cleared").Inc()
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
-		_, err = db.Exec(
-			`INSERT INTO post_votes (post_id, user_id, is_positive) VALUES ($1, $2, $3)
+		res, err := db.ExecContext(r.Context(),
+			`INSERT INTO post_votes (post_id, user_id, is_positive)
+			 SELECT id, $2::int, $3::boolean FROM posts WHERE id = $1 AND deleted_at IS NULL
 			 ON CONFLICT (post_id, user_id) DO UPDATE SET is_positive = EXCLUDED.is_positive`,
-			payload.PostID,
+			postID,
 			userID,
 			*payload.IsPositive,
 		)
 		if err != nil {
-			log.Println("Database error:", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+		if !matched(res) {
+			problem.Write(w, r, errPostNotFound)
 			return
 		}
 
-		w.WriteHeader(http.StatusCreated)
+		direction := "down"
+		if *payload.IsPositive {
+			direction = "up"
+		}
+		metrics.Votes.WithLabelValues(direction).Inc()
+
+		// The legacy /votepost route answers a vote with 201, and only
+		// clearing one with 204.
+		if r.PathValue("id") == "" {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
 	})
 }
 
-func AddPost(db *sql.DB) http.HandlerFunc {
+func AddPost(db *sql.DB, tokens *auth.Tokens) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		header := r.Header.Get("Authorization")
 		tokenStr := strings.TrimPrefix(header, "Bearer ")
-		userID, err := auth.VerifyToken(tokenStr)
+		userID, err := tokens.Verify(tokenStr)
 		if err != nil {
-			http.Error(w, "Invalid Token.", http.StatusUnauthorized)
+			problem.Write(w, r, errInvalidToken)
 			return
 		}
 
-		var t models.Post
+		var t CreatePostRequest
 
-		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-			http.Error(w, "Invalid JSON.", http.StatusBadRequest)
-			log.Println("Error decoding JSON:", err)
+		if err := decodeJSON(w, r, &t, maxJSONBody); err != nil {
+			problem.Write(w, r, err)
 			return
 		}
 
-		if len(t.Title) > 100 {
-			http.Error(w, "Post title too long.", http.StatusBadRequest)
+		if err := t.Validate(); err != nil {
+			problem.Write(w, r, err)
 			return
-		} else if len(t.Body) > 3000 {
-			http.Error(w, "Post description too long.", http.StatusBadRequest)
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			problem.Write(w, r, problem.Internal(err))
 			return
 		}
+		defer tx.Rollback()
 
-		_, err = db.Exec(
-			`INSERT INTO posts (title, body, topic, creator) VALUES ($1, $2, $3, $4)`,
+		var postID int
+		err = tx.QueryRowContext(r.Context(),
+			`INSERT INTO posts (title, body, topic, creator, body_html) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
 			t.Title,
 			t.Body,
 			t.Topic,
 			userID,
-		)
+			markdown.Render(t.Body),
+		).Scan(&postID)
+		if isForeignKeyViolation(err) {
+			problem.Write(w, r, problem.Invalid("topic", "Topic not found."))
+			return
+		} else if err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+
+		if err := attachMedia(r.Context(), tx, postID, userID, t.Attachments); err != nil {
+			writeAttachError(w, r, err)
+			return
+		}
+
+		post, err := store.Post(r.Context(), tx, postID, userID)
 		if err != nil {
-			log.Println("Database error:", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.Write(w, r, problem.Internal(err))
 			return
 		}
 
-		w.WriteHeader(http.StatusCreated)
+		if err := tx.Commit(); err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+		metrics.PostsCreated.Inc()
+
+		w.Header().Set("Location", APIPrefix+"/posts/"+strconv.Itoa(postID))
+		w.Header().Set("ETag", etag(post.Version))
+		writeJSON(w, http.StatusCreated, post)
 	})
 }
 
-func EditPost(db *sql.DB) http.HandlerFunc {
+func EditPost(db *sql.DB, tokens *auth.Tokens) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var t models.Post
+		var t UpdatePostRequest
 
 		header := r.Header.Get("Authorization")
 		tokenStr := strings.TrimPrefix(header, "Bearer ")
-		userID, err := auth.VerifyToken(tokenStr)
+		userID, err := tokens.Verify(tokenStr)
 		if err != nil {
-			http.Error(w, "Invalid Token.", http.StatusUnauthorized)
+			problem.Write(w, r, errInvalidToken)
+			return
+		}
+
+		if err := decodeJSON(w, r, &t, maxJSONBody); err != nil {
+			problem.Write(w, r, err)
+			return
+		}
+
+		var ok bool
+		if t.ID, ok = pathID(r, t.ID); !ok {
+			problem.Write(w, r, errPostNotFound)
 			return
 		}
 
-		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-			http.Error(w, "Invalid JSON.", http.StatusBadRequest)
-			log.Println("Error decoding JSON:", err)
+		if err := t.Validate(); err != nil {
+			problem.Write(w, r, err)
 			return
 		}
 
-		if len(t.Title) > 100 {
-			http.Error(w, "Post title too long.", http.StatusBadRequest)
+		versions, err := expectedVersions(r, t.Version)
+		if err != nil {
+			problem.Write(w, r, err)
 			return
-		} else if len(t.Body) > 3000 {
-			http.Error(w, "Post description too long.", http.StatusBadRequest)
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			problem.Write(w, r, problem.Internal(err))
 			return
 		}
+		defer tx.Rollback()
 
-		_, err = db.Exec(
+		err = tx.QueryRowContext(r.Context(),
 			`UPDATE posts 
-			SET title = $1, body = $2, is_edited = TRUE
-			WHERE id = $3 AND creator = $4`,
+			SET title = $1, body = $2, body_html = $6, is_edited = TRUE, version = version + 1
+			WHERE id = $3 AND creator = $4 AND deleted_at IS NULL
+				AND ($5::bigint[] IS NULL OR version = ANY($5))
+			RETURNING id`,
 			t.Title,
 			t.Body,
 			t.ID,
 			userID,
-		)
+			versions,
+			markdown.Render(t.Body),
+		).Scan(&t.ID)
+
+		if errors.Is(err, sql.ErrNoRows) {
+			problem.Write(w, r, unmatchedError(r.Context(), tx, "posts", t.ID, userID, errPostNotFound))
+			return
+		} else if err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
 
+		// Omitting attachments keeps the current ones; an empty list removes them.
+		if t.Attachments != nil {
+			if err := attachMedia(r.Context(), tx, t.ID, userID, t.Attachments); err != nil {
+				writeAttachError(w, r, err)
+				return
+			}
+		}
+
+		post, err := store.Post(r.Context(), tx, t.ID, userID)
 		if err != nil {
-			log.Println("Database error:", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.Write(w, r, problem.Internal(err))
 			return
 		}
 
-		w.WriteHeader(http.StatusCreated)
+		if err := tx.Commit(); err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+
+		w.Header().Set("ETag", etag(post.Version))
+		writeJSON(w, http.StatusOK, post)
 	})
 }
 
-func DeletePost(db *sql.DB) http.HandlerFunc {
+func DeletePost(db *sql.DB, tokens *auth.Tokens) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var t models.Post
+		var t UpdatePostRequest
 
 		header := r.Header.Get("Authorization")
 		tokenStr := strings.TrimPrefix(header, "Bearer ")
-		userID, err := auth.VerifyToken(tokenStr)
+		userID, err := tokens.Verify(tokenStr)
 		if err != nil {
-			http.Error(w, "Invalid Token.", http.StatusUnauthorized)
+			problem.Write(w, r, errInvalidToken)
 			return
 		}
 
-		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-			http.Error(w, "Invalid JSON.", http.StatusBadRequest)
-			log.Println("Error decoding JSON:", err)
+		if r.PathValue("id") == "" {
+			if err := decodeJSON(w, r, &t, maxJSONBody); err != nil {
+				problem.Write(w, r, err)
+				return
+			}
+		}
+
+		var ok bool
+		if t.ID, ok = pathID(r, t.ID); !ok {
+			problem.Write(w, r, errPostNotFound)
 			return
 		}
 
-		_, err = db.Exec(
+		res, err := db.ExecContext(r.Context(),
 			`DELETE FROM posts 
-			WHERE id = $1 AND creator = $2`,
+			WHERE id = $1 AND creator = $2 AND deleted_at IS NULL`,
 			t.ID,
 			userID,
 		)
 
 		if err != nil {
-			log.Println("Database error:", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.Write(w, r, problem.Internal(err))
 			return
 		}
 
-		w.WriteHeader(http.StatusCreated)
+		if !matched(res) {
+			err := unmatchedError(r.Context(), db, "posts", t.ID, userID, errPostNotFound)
+			if err == errNotOwner {
+				err = removeAsModerator(r.Context(), db, userID, func() error {
+					return store.SetPostDeleted(r.Context(), db, t.ID, true)
+				})
+			}
+			if err != nil {
+				problem.Write(w, r, err)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
 	})
 }
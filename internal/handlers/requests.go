@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"backend/internal/models"
+	"backend/internal/validate"
+	"fmt"
+	"regexp"
+)
+
+var (
+	usernamePattern  = regexp.MustCompile(`^\d*[a-zA-Z][a-zA-Z0-9]*$`)
+	topicNamePattern = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+)
+
+type LoginRequest struct {
+	Username string `json:"username"`
+}
+
+func (req *LoginRequest) Validate() error {
+	var v validate.Validator
+	v.String("username", &req.Username,
+		validate.Trim,
+		validate.Required,
+		validate.Length(1, 20),
+		validate.Pattern(usernamePattern, "must be alphanumeric, and must have at least one alphabetical character."),
+	)
+	return v.Err()
+}
+
+type TopicRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	ImageBase64 string `json:"image,omitempty"`
+	Version     int    `json:"version,omitempty"`
+}
+
+func (req *TopicRequest) Validate() error {
+	var v validate.Validator
+	v.String("name", &req.Name,
+		validate.Trim,
+		validate.Required,
+		validate.Length(1, 50),
+		validate.Pattern(topicNamePattern, "must contain only alphanumeric characters."),
+	)
+	v.String("description", &req.Description, validate.Trim, validate.Length(0, 1000))
+	return v.Err()
+}
+
+// ValidateTopic checks a topic created outside the API, such as with
+// forumctl, as topic requests are checked, and returns it normalised.
+func ValidateTopic(name, description string) (string, string, error) {
+	req := TopicRequest{Name: name, Description: description}
+	err := req.Validate()
+	return req.Name, req.Description, err
+}
+
+type CreatePostRequest struct {
+	Title       string              `json:"title"`
+	Body        string              `json:"body"`
+	Topic       string              `json:"topic"`
+	Attachments []models.Attachment `json:"attachments"`
+}
+
+func (req *CreatePostRequest) Validate() error {
+	var v validate.Validator
+	validatePostContent(&v, &req.Title, &req.Body, req.Attachments)
+	v.String("topic", &req.Topic, validate.Trim, validate.Required)
+	return v.Err()
+}
+
+type UpdatePostRequest struct {
+	ID          int                 `json:"id"`
+	Title       string              `json:"title"`
+	Body        string              `json:"body"`
+	Attachments []models.Attachment `json:"attachments"`
+	Version     int                 `json:"version,omitempty"`
+}
+
+func (req *UpdatePostRequest) Validate() error {
+	var v validate.Validator
+	validatePostContent(&v, &req.Title, &req.Body, req.Attachments)
+	return v.Err()
+}
+
+func validatePostContent(v *validate.Validator, title, body *string, attachments []models.Attachment) {
+	v.String("title", title, validate.Trim, validate.Required, validate.Length(1, 100))
+	v.String("body", body, validate.Trim, validate.Required, validate.Length(1, 3000))
+	v.Check(len(attachments) <= maxAttachments, "attachments",
+		fmt.Sprintf("A post can have at most %d attachments.", maxAttachments))
+	for i := range attachments {
+		v.String(fmt.Sprintf("attachments[%d].alt", i), &attachments[i].Alt,
+			validate.Trim, validate.Length(0, maxAltLength))
+	}
+}
+
+type CommentRequest struct {
+	ID      int    `json:"id"`
+	Post    int    `json:"post"`
+	Parent  *int   `json:"parent,omitempty"`
+	Body    string `json:"body"`
+	Version int    `json:"version,omitempty"`
+}
+
+func (req *CommentRequest) Validate() error {
+	var v validate.Validator
+	v.String("body", &req.Body, validate.Trim, validate.Required, validate.Length(1, 500))
+	return v.Err()
+}
+
+type VoteRequest struct {
+	PostID     int   `json:"post_id"`
+	IsPositive *bool `json:"is_positive"`
+}
+
+type ImageRequest struct {
+	ImageBase64 string `json:"image,omitempty"`
+}
+
+// Validate requires an image, as uploads to /media are only ever images.
+// Profile edits use ImageRequest without calling it, since the image there
+// is optional.
+func (req *ImageRequest) Validate() error {
+	var v validate.Validator
+	v.String("image", &req.ImageBase64, validate.Required)
+	return v.Err()
+}
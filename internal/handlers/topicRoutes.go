@@ -1,50 +1,24 @@
 package handlers
 
 import (
+	"backend/internal/blob"
 	"backend/internal/models"
+	"backend/internal/problem"
+	"backend/internal/store"
+	"context"
 	"database/sql"
-	"encoding/base64"
 	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
-	"regexp"
 )
 
 func GetTopics(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query(`
-            SELECT name, description, image IS NOT NULL, EXTRACT(EPOCH FROM image_updated_at)
-            FROM topics
-        `)
+		topics, err := store.Topics(r.Context(), db)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.Write(w, r, problem.Internal(err))
 			return
 		}
-		defer rows.Close()
-
-		var topics []models.Topic
-
-		for rows.Next() {
-			var (
-				t          models.Topic
-				hasImage   bool
-				imageEpoch float64
-			)
-
-			if err := rows.Scan(&t.Name, &t.Description, &hasImage, &imageEpoch); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				log.Println(err.Error())
-				return
-			}
-
-			if hasImage {
-				url := "/topics/" + t.Name + "/image"
-				t.ImageURL = &url
-				t.ImageUpdatedAt = int64(imageEpoch)
-			}
-
-			topics = append(topics, t)
-		}
 
 		json.NewEncoder(w).Encode(topics)
 	}
@@ -52,195 +26,201 @@ func GetTopics(db *sql.DB) http.HandlerFunc {
 
 func GetTopic(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		name := r.PathValue("name")
-
-		var (
-			t          models.Topic
-			hasImage   bool
-			imageEpoch float64
-		)
-
-		err := db.QueryRow(
-			`SELECT name, description, image IS NOT NULL, EXTRACT(EPOCH FROM image_updated_at)
-             FROM topics WHERE name = $1`,
-			name,
-		).Scan(&t.Name, &t.Description, &hasImage, &imageEpoch)
-
-		if err != nil {
-			http.Error(w, "Topic not found.", http.StatusNotFound)
-			log.Println(err.Error())
+		t, err := store.Topic(r.Context(), db, r.PathValue("name"))
+		if errors.Is(err, store.ErrNotFound) {
+			problem.Write(w, r, errTopicNotFound)
+			return
+		} else if err != nil {
+			problem.Write(w, r, problem.Internal(err))
 			return
 		}
 
-		if hasImage {
-			url := "/topics/" + t.Name + "/image"
-			t.ImageURL = &url
-			t.ImageUpdatedAt = int64(imageEpoch)
-		}
-
+		w.Header().Set("ETag", etag(t.Version))
 		json.NewEncoder(w).Encode(t)
 	}
 }
 
-func GetTopicImage(db *sql.DB) http.HandlerFunc {
+func GetTopicImage(db *sql.DB, blobs blob.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		topicName := r.PathValue("name")
 
-		row := db.QueryRow(
-			`SELECT image FROM topics WHERE name = $1`,
+		row := db.QueryRowContext(r.Context(),
+			`SELECT image, image_key, EXTRACT(EPOCH FROM image_updated_at) FROM topics WHERE name = $1`,
 			topicName,
 		)
 
-		var image []byte
-		if err := row.Scan(&image); err != nil {
-			http.Error(w, "Image not found.", http.StatusNotFound)
+		var (
+			legacy     []byte
+			key        sql.NullString
+			imageEpoch float64
+		)
+		if err := row.Scan(&legacy, &key, &imageEpoch); err != nil {
+			problem.Write(w, r, errImageNotFound)
 			return
 		}
-		w.Header().Set("Cache-Control", "no-store")
-		w.Header().Set("Content-Type", "image/png")
-		w.Write(image)
+
+		serveImage(w, r, blobs, key, legacy, versionCache(r, imageEpoch))
 	}
 }
 
-func AddTopic(db *sql.DB) http.Handler {
+func AddTopic(db *sql.DB, blobs blob.Store) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var t struct {
-			Name        string `json:"name"`
-			Description string `json:"description"`
-			ImageBase64 string `json:"image,omitempty"`
-		}
+		var t TopicRequest
 
-		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-			http.Error(w, "Invalid JSON.", http.StatusBadRequest)
-			log.Println("Error decoding JSON:", err)
+		if err := decodeJSON(w, r, &t, maxImageJSONBody); err != nil {
+			problem.Write(w, r, err)
 			return
 		}
 
-		if len(t.Name) > 50 {
-			http.Error(w, "Topic name too long.", http.StatusBadRequest)
-			return
-		} else if !regexp.MustCompile("^[a-zA-Z0-9]*$").MatchString(t.Name) {
-			http.Error(w, "Topic name must contain only alphanumeric characters.", http.StatusBadRequest)
-			return
-		} else if len(t.Description) > 1000 {
-			http.Error(w, "Topic description too long.", http.StatusBadRequest)
+		if err := t.Validate(); err != nil {
+			problem.Write(w, r, err)
 			return
 		}
 
-		var imgBytes interface{} = nil
+		var imgKey interface{} = nil
 
 		if t.ImageBase64 != "" {
-			decoded, err := base64.StdEncoding.DecodeString(t.ImageBase64)
+			decoded, err := decodeImage(t.ImageBase64)
 			if err != nil {
-				http.Error(w, "Invalid base64 image.", http.StatusBadRequest)
+				problem.Write(w, r, problem.Invalid("image", err.Error()))
 				return
 			}
-			if len(decoded) > 2<<20 {
-				http.Error(w, "Image too large.", http.StatusBadRequest)
+			key, err := storeImage(r.Context(), blobs, "topics/"+t.Name, decoded)
+			if err != nil {
+				problem.Write(w, r, problem.Internal(err))
 				return
 			}
-			imgBytes = decoded
+			imgKey = key
 		}
 
-		_, err := db.Exec(
-			`INSERT INTO topics (name, description, image) VALUES ($1, $2, $3)`,
-			t.Name,
-			t.Description,
-			imgBytes,
-		)
-		if err != nil {
-			log.Println("Database error:", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		topic, err := store.CreateTopic(r.Context(), db, t.Name, t.Description, imgKey)
+		if errors.Is(err, store.ErrExists) {
+			problem.Write(w, r, problem.New(http.StatusConflict, "topic_exists", "Topic already exists."))
+			return
+		} else if err != nil {
+			problem.Write(w, r, problem.Internal(err))
 			return
 		}
 
-		w.WriteHeader(http.StatusCreated)
+		w.Header().Set("Location", APIPrefix+"/topics/"+topic.Name)
+		w.Header().Set("ETag", etag(topic.Version))
+		writeJSON(w, http.StatusCreated, topic)
 	})
 }
 
-func EditTopic(db *sql.DB) http.HandlerFunc {
+func EditTopic(db *sql.DB, blobs blob.Store) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var t struct {
-			Name        string `json:"name"`
-			Description string `json:"description"`
-			ImageBase64 string `json:"image,omitempty"`
-		}
+		var t TopicRequest
 
-		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-			http.Error(w, "Invalid JSON.", http.StatusBadRequest)
-			log.Println("Error decoding JSON:", err)
+		if err := decodeJSON(w, r, &t, maxImageJSONBody); err != nil {
+			problem.Write(w, r, err)
 			return
 		}
 
-		if len(t.Name) > 50 {
-			http.Error(w, "Topic name too long.", http.StatusBadRequest)
-			return
-		} else if !regexp.MustCompile("^[a-zA-Z0-9]*$").MatchString(t.Name) {
-			http.Error(w, "Topic name must contain only alphanumeric characters.", http.StatusBadRequest)
+		if name := r.PathValue("name"); name != "" {
+			t.Name = name
+		}
+
+		if err := t.Validate(); err != nil {
+			problem.Write(w, r, err)
 			return
-		} else if len(t.Description) > 1000 {
-			http.Error(w, "Topic description too long.", http.StatusBadRequest)
+		}
+
+		versions, err := expectedVersions(r, t.Version)
+		if err != nil {
+			problem.Write(w, r, err)
 			return
 		}
 
-		var imgBytes interface{} = nil
+		var imgKey interface{} = nil
 
 		if t.ImageBase64 != "" {
-			decoded, err := base64.StdEncoding.DecodeString(t.ImageBase64)
+			decoded, err := decodeImage(t.ImageBase64)
 			if err != nil {
-				http.Error(w, "Invalid base64 image.", http.StatusBadRequest)
+				problem.Write(w, r, problem.Invalid("image", err.Error()))
 				return
 			}
-			if len(decoded) > 2<<20 {
-				http.Error(w, "Image too large.", http.StatusBadRequest)
+			key, err := storeImage(r.Context(), blobs, "topics/"+t.Name, decoded)
+			if err != nil {
+				problem.Write(w, r, problem.Internal(err))
 				return
 			}
-			imgBytes = decoded
+			imgKey = key
 		}
 
-		_, err := db.Exec(
-			`UPDATE topics 
-			SET description = $2, image = COALESCE($3, image), 
+		var oldKey sql.NullString
+		topic, err := store.ScanTopic(db.QueryRowContext(r.Context(),
+			`WITH old AS (SELECT image_key FROM topics WHERE name = $1)
+			UPDATE topics 
+			SET description = $2, image_key = COALESCE($3, image_key), 
+				image = CASE WHEN $3 IS NOT NULL THEN NULL ELSE image END,
 				image_updated_at = CASE WHEN $3 IS NOT NULL THEN now() 
-										ELSE image_updated_at END
-			WHERE name = $1`,
+										ELSE image_updated_at END,
+				version = version + 1
+			WHERE name = $1 AND ($4::bigint[] IS NULL OR version = ANY($4))
+			RETURNING `+store.TopicColumns+`, (SELECT image_key FROM old)`,
 			t.Name,
 			t.Description,
-			imgBytes,
-		)
+			imgKey,
+			versions,
+		), &oldKey)
 
-		if err != nil {
-			log.Println("Database error:", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err == sql.ErrNoRows {
+			problem.Write(w, r, staleTopicError(r.Context(), db, blobs, t.Name, imgKey))
 			return
+		} else if err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+
+		if imgKey != nil && oldKey.String != imgKey {
+			DiscardImage(r.Context(), blobs, oldKey)
 		}
 
-		w.WriteHeader(http.StatusAccepted)
+		w.Header().Set("ETag", etag(topic.Version))
+		writeJSON(w, http.StatusOK, topic)
 	})
 }
 
-func DeleteTopic(db *sql.DB) http.HandlerFunc {
+// staleTopicError explains why a topic edit matched no rows, and discards
+// the image uploaded with it unless the topic already uses that image.
+func staleTopicError(ctx context.Context, db *sql.DB, blobs blob.Store, name string, imgKey any) error {
+	var current sql.NullString
+	err := db.QueryRowContext(ctx, `SELECT image_key FROM topics WHERE name = $1`, name).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		return problem.Internal(err)
+	}
+	if key, ok := imgKey.(string); ok && current.String != key {
+		DiscardImage(ctx, blobs, sql.NullString{String: key, Valid: true})
+	}
+	if err == sql.ErrNoRows {
+		return errTopicNotFound
+	}
+	return errPreconditionFailed
+}
+
+func DeleteTopic(db *sql.DB, blobs blob.Store) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var t models.Topic
 
-		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-			http.Error(w, "Invalid JSON.", http.StatusBadRequest)
-			log.Println("Error decoding JSON:", err)
+		if name := r.PathValue("name"); name != "" {
+			t.Name = name
+		} else if err := decodeJSON(w, r, &t, maxJSONBody); err != nil {
+			problem.Write(w, r, err)
 			return
 		}
 
-		_, err := db.Exec(
-			`DELETE FROM TOPICS
-			WHERE name = $1`,
-			t.Name,
-		)
-
-		if err != nil {
-			log.Println("Database error:", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		oldKey, err := store.DeleteTopic(r.Context(), db, t.Name)
+		if errors.Is(err, store.ErrNotFound) {
+			problem.Write(w, r, errTopicNotFound)
+			return
+		} else if err != nil {
+			problem.Write(w, r, problem.Internal(err))
 			return
 		}
 
-		w.WriteHeader(http.StatusAccepted)
+		DiscardImage(r.Context(), blobs, oldKey)
+
+		w.WriteHeader(http.StatusNoContent)
 	})
 }
@@ -1,143 +1,259 @@
 package handlers
 
 import (
+	"backend/internal/archive"
 	"backend/internal/auth"
-	"backend/internal/models"
+	"backend/internal/blob"
+	"backend/internal/logging"
+	"backend/internal/problem"
+	"backend/internal/store"
+	"bytes"
+	"context"
 	"database/sql"
-	"encoding/base64"
 	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
-	"unicode"
+	"time"
 )
 
 func GetUser(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-
-		var (
-			t          models.User
-			hasImage   bool
-			imageEpoch float64
-			err        error
-		)
-
-		if unicode.IsDigit(rune(id[0])) {
-			err = db.QueryRow(
-				`SELECT id, username, image IS NOT NULL, EXTRACT(EPOCH FROM image_updated_at)
-	             FROM users WHERE id = $1`,
-				id,
-			).Scan(&t.ID, &t.Username, &hasImage, &imageEpoch)
-		} else {
-			err = db.QueryRow(
-				`SELECT id, username, image IS NOT NULL, EXTRACT(EPOCH FROM image_updated_at)
-				FROM users WHERE username = $1`,
-				id,
-			).Scan(&t.ID, &t.Username, &hasImage, &imageEpoch)
-		}
-
-		if err != nil {
-			if err == sql.ErrNoRows {
-				http.Error(w, "User not found", http.StatusNotFound)
-			} else {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-			}
+		t, err := store.User(r.Context(), db, r.PathValue("id"))
+		if errors.Is(err, store.ErrNotFound) {
+			problem.Write(w, r, errUserNotFound)
+			return
+		} else if err != nil {
+			problem.Write(w, r, problem.Internal(err))
 			return
-		}
-
-		if hasImage {
-			url := "/user/" + t.Username + "/image"
-			t.ImageURL = &url
-			t.ImageUpdatedAt = int64(imageEpoch)
 		}
 
 		json.NewEncoder(w).Encode(t)
 	}
 }
 
-func GetUserImage(db *sql.DB) http.HandlerFunc {
+func GetUserImage(db *sql.DB, blobs blob.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-
 		var (
-			image []byte
-			err   error
+			legacy     []byte
+			key        sql.NullString
+			imageEpoch float64
 		)
-
-		if unicode.IsDigit(rune(id[0])) {
-			err = db.QueryRow(
-				`SELECT image FROM users WHERE id = $1`,
-				id,
-			).Scan(&image)
-		} else {
-			err = db.QueryRow(
-				`SELECT image FROM users WHERE username = $1`,
-				id,
-			).Scan(&image)
-		}
-
+		where, arg := store.UserRef(r.PathValue("id"))
+		err := db.QueryRowContext(r.Context(),
+			`SELECT image, image_key, EXTRACT(EPOCH FROM image_updated_at) FROM users WHERE `+where,
+			arg,
+		).Scan(&legacy, &key, &imageEpoch)
 		if err != nil {
-			http.Error(w, "Image not found.", http.StatusNotFound)
+			problem.Write(w, r, errImageNotFound)
 			return
 		}
 
-		w.Header().Set("Content-Type", "image/png")
-		w.Write(image)
+		serveImage(w, r, blobs, key, legacy, versionCache(r, imageEpoch))
 	}
 }
 
-func EditUser(db *sql.DB) http.HandlerFunc {
+func EditUser(db *sql.DB, tokens *auth.Tokens, blobs blob.Store) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var t struct {
-			ImageBase64 string `json:"image,omitempty"`
-		}
+		var t ImageRequest
 
 		header := r.Header.Get("Authorization")
 		tokenStr := strings.TrimPrefix(header, "Bearer ")
-		userID, err := auth.VerifyToken(tokenStr)
+		userID, err := tokens.Verify(tokenStr)
 		if err != nil {
-			http.Error(w, "Invalid Token.", http.StatusUnauthorized)
+			problem.Write(w, r, errInvalidToken)
 			return
 		}
 
-		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-			http.Error(w, "Invalid JSON.", http.StatusBadRequest)
-			log.Println("Error decoding JSON:", err)
+		if err := decodeJSON(w, r, &t, maxImageJSONBody); err != nil {
+			problem.Write(w, r, err)
 			return
 		}
 
-		var imgBytes any = nil
+		var imgKey any = nil
 
 		if t.ImageBase64 != "" {
-			decoded, err := base64.StdEncoding.DecodeString(t.ImageBase64)
+			decoded, err := decodeImage(t.ImageBase64)
 			if err != nil {
-				http.Error(w, "Invalid base64 image.", http.StatusBadRequest)
+				problem.Write(w, r, problem.Invalid("image", err.Error()))
 				return
 			}
-			if len(decoded) > 2<<20 {
-				http.Error(w, "Image too large.", http.StatusBadRequest)
+			key, err := storeImage(r.Context(), blobs, "users/"+strconv.Itoa(userID), decoded)
+			if err != nil {
+				problem.Write(w, r, problem.Internal(err))
 				return
 			}
-			imgBytes = decoded
+			imgKey = key
 		}
 
-		_, err = db.Exec(
-			`UPDATE users 
-			SET image = COALESCE($1, image), 
+		var oldKey sql.NullString
+		user, err := store.ScanUser(db.QueryRowContext(r.Context(),
+			`WITH old AS (SELECT image_key FROM users WHERE id = $2)
+			UPDATE users 
+			SET image_key = COALESCE($1, image_key), 
+				image = CASE WHEN $1 IS NOT NULL THEN NULL ELSE image END,
 				image_updated_at = CASE WHEN $1 IS NOT NULL THEN now() 
 										ELSE image_updated_at END
-			WHERE id = $2`,
-			imgBytes,
+			WHERE id = $2
+			RETURNING `+store.UserColumns+`, (SELECT image_key FROM old)`,
+			imgKey,
 			userID,
-		)
+		), &oldKey)
 
 		if err != nil {
-			log.Println("Database error:", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.Write(w, r, problem.Internal(err))
 			return
 		}
 
-		w.WriteHeader(http.StatusAccepted)
+		if imgKey != nil && oldKey.String != imgKey {
+			DiscardImage(r.Context(), blobs, oldKey)
+		}
+
+		writeJSON(w, http.StatusOK, user)
 	})
 }
+
+// ExportUserData sends the logged in user a zip file of their profile,
+// image, posts, uploads, comments and votes.
+func ExportUserData(db *sql.DB, tokens *auth.Tokens, blobs blob.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		tokenStr := strings.TrimPrefix(header, "Bearer ")
+		userID, err := tokens.Verify(tokenStr)
+		if err != nil {
+			problem.Write(w, r, errInvalidToken)
+			return
+		}
+
+		// The archive is built in memory first, so a failure halfway is
+		// reported as such rather than as a truncated download.
+		var buf bytes.Buffer
+		if _, err := archive.ExportUser(r.Context(), db, blobs, userID, &buf); err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="forum-data-`+strconv.Itoa(userID)+`.zip"`)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(buf.Bytes())
+	}
+}
+
+func GetAccountDeletion(db *sql.DB, tokens *auth.Tokens, grace time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		tokenStr := strings.TrimPrefix(header, "Bearer ")
+		userID, err := tokens.Verify(tokenStr)
+		if err != nil {
+			problem.Write(w, r, errInvalidToken)
+			return
+		}
+
+		d, err := store.AccountDeletion(r.Context(), db, userID, grace)
+		if errors.Is(err, store.ErrNotFound) {
+			problem.Write(w, r, errNoDeletion)
+			return
+		} else if err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+
+		writeJSON(w, http.StatusOK, d)
+	}
+}
+
+// RequestAccountDeletion schedules the logged in user's account to be
+// deleted once grace has passed. Until then the user can cancel it.
+func RequestAccountDeletion(db *sql.DB, tokens *auth.Tokens, grace time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		tokenStr := strings.TrimPrefix(header, "Bearer ")
+		userID, err := tokens.Verify(tokenStr)
+		if err != nil {
+			problem.Write(w, r, errInvalidToken)
+			return
+		}
+
+		d, err := store.RequestAccountDeletion(r.Context(), db, userID, grace)
+		if err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, d)
+	}
+}
+
+func CancelAccountDeletion(db *sql.DB, tokens *auth.Tokens) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		tokenStr := strings.TrimPrefix(header, "Bearer ")
+		userID, err := tokens.Verify(tokenStr)
+		if err != nil {
+			problem.Write(w, r, errInvalidToken)
+			return
+		}
+
+		err = store.CancelAccountDeletion(r.Context(), db, userID)
+		if errors.Is(err, store.ErrNotFound) {
+			problem.Write(w, r, errNoDeletion)
+			return
+		} else if err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DeleteAccount deletes a user right away, as store.DeleteAccount
+// describes, and discards the images that only they used.
+func DeleteAccount(ctx context.Context, db *sql.DB, blobs blob.Store, userID int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	keys, err := store.DeleteAccount(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		DiscardImage(ctx, blobs, key)
+	}
+	return nil
+}
+
+// PurgeAccounts deletes the accounts whose deletion was requested more
+// than grace ago.
+func PurgeAccounts(db *sql.DB, blobs blob.Store, grace time.Duration) (int, error) {
+	ctx := context.Background()
+	ids, err := store.AccountsDueForDeletion(ctx, db, grace)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, id := range ids {
+		err := DeleteAccount(ctx, db, blobs, id)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			// Deleted in the meantime with forumctl.
+		case errors.Is(err, store.ErrDeletedUser):
+			logging.FromContext(ctx).Warn("Skipped deleting the account of deleted users", "user", id)
+		case err != nil:
+			return n, err
+		default:
+			n++
+		}
+	}
+	return n, nil
+}
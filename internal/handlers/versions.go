@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"backend/internal/problem"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+var (
+	errPreconditionRequired = problem.New(http.StatusPreconditionRequired, "precondition_required",
+		"Send If-Match with the resource's ETag, or its version, so concurrent edits are not lost.")
+	errPreconditionFailed = problem.New(http.StatusPreconditionFailed, "precondition_failed",
+		"The resource has changed since it was read. Fetch it again and retry.")
+)
+
+// etag identifies a revision of a post, comment or topic.
+func etag(version int) string {
+	return `"v` + strconv.Itoa(version) + `"`
+}
+
+// expectedVersions returns the versions an edit may apply to, taken from
+// If-Match or else from the version in the request body. A nil result
+// matches any version; an empty one matches none.
+//
+// /api/v1 edits must name a version, since an unconditional edit silently
+// overwrites concurrent ones. Legacy routes predate versions and are exempt.
+func expectedVersions(r *http.Request, bodyVersion int) (any, error) {
+	header := r.Header.Get("If-Match")
+	switch {
+	case header != "":
+		versions := []int64{}
+		for _, tag := range strings.Split(header, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" {
+				return nil, nil
+			}
+			s, ok := strings.CutPrefix(tag, `"v`)
+			if !ok {
+				continue
+			}
+			if s, ok = strings.CutSuffix(s, `"`); !ok {
+				continue
+			}
+			if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+				versions = append(versions, v)
+			}
+		}
+		return pq.Array(versions), nil
+	case bodyVersion != 0:
+		return pq.Array([]int64{int64(bodyVersion)}), nil
+	case isLegacy(r):
+		return nil, nil
+	default:
+		return nil, errPreconditionRequired
+	}
+}
+
+// isLegacy reports whether r came in through a pre-v1 route.
+func isLegacy(r *http.Request) bool {
+	return !strings.Contains(r.Pattern, " "+APIPrefix+"/")
+}
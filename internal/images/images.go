@@ -0,0 +1,223 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"slices"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+const (
+	// MaxDimension bounds the width and height of uploads. Checking it
+	// before decoding keeps small files from expanding into huge bitmaps.
+	MaxDimension = 4096
+
+	// MaxGIFPixels bounds the pixels of all frames of a GIF together, as
+	// decoding an animation holds every frame in memory at once.
+	MaxGIFPixels = 64 << 20
+
+	jpegQuality = 90
+)
+
+// Sizes are the thumbnail edge lengths clients may request with ?size=.
+var Sizes = []int{64, 128, 512}
+
+var (
+	ErrUnsupported = errors.New("Image must be a PNG, JPEG, GIF or WebP file.")
+	ErrDimensions  = fmt.Errorf("Image must be at most %dx%d pixels.", MaxDimension, MaxDimension)
+	ErrFrames      = errors.New("Animated image has too many frames for its size.")
+)
+
+// Sanitize checks that data is a well-formed PNG, JPEG, GIF or WebP image of
+// acceptable dimensions and returns it with EXIF and other metadata removed.
+// JPEGs are turned upright first, as their EXIF orientation goes too.
+func Sanitize(data []byte) ([]byte, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	if cfg.Width < 1 || cfg.Height < 1 || cfg.Width > MaxDimension || cfg.Height > MaxDimension {
+		return nil, ErrDimensions
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrUnsupported
+		}
+		img = orient(img, jpegOrientation(data))
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+		return buf.Bytes(), err
+	case "png":
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrUnsupported
+		}
+		err = png.Encode(&buf, img)
+		return buf.Bytes(), err
+	case "gif":
+		// Re-encoding every frame keeps animations but drops comment and
+		// application extensions.
+		pixels, err := gifPixels(data)
+		if err != nil {
+			return nil, err
+		}
+		if pixels > MaxGIFPixels {
+			return nil, ErrFrames
+		}
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrUnsupported
+		}
+		err = gif.EncodeAll(&buf, g)
+		return buf.Bytes(), err
+	case "webp":
+		// There is no WebP encoder in the standard library, so the file is
+		// decoded to validate it and then stripped chunk by chunk.
+		if _, err := webp.Decode(bytes.NewReader(data)); err != nil {
+			return nil, ErrUnsupported
+		}
+		return stripWebP(data)
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// Thumbnail scales data to fit within a size x size square. Images that
+// already fit are returned unchanged.
+func Thumbnail(data []byte, size int) ([]byte, error) {
+	if !slices.Contains(Sizes, size) {
+		return nil, fmt.Errorf("unsupported thumbnail size %d", size)
+	}
+
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	b := src.Bounds()
+	if b.Dx() <= size && b.Dy() <= size {
+		return data, nil
+	}
+
+	w, h := size, size
+	if b.Dx() > b.Dy() {
+		h = max(1, b.Dy()*size/b.Dx())
+	} else {
+		w = max(1, b.Dx()*size/b.Dy())
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		err = png.Encode(&buf, dst)
+	}
+	return buf.Bytes(), err
+}
+
+// gifPixels adds up the sizes of the frames of a GIF from their image
+// descriptors, without decoding any of them. It stops counting once the
+// total is over MaxGIFPixels.
+func gifPixels(data []byte) (int, error) {
+	if len(data) < 13 {
+		return 0, ErrUnsupported
+	}
+	pos := 13
+	if data[10]&0x80 != 0 {
+		pos += 3 << (data[10]&0x07 + 1)
+	}
+
+	// skipSubBlocks moves pos past a sequence of data sub-blocks.
+	skipSubBlocks := func() bool {
+		for pos < len(data) {
+			n := int(data[pos])
+			pos += 1 + n
+			if n == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	pixels := 0
+	for pos < len(data) && pixels <= MaxGIFPixels {
+		switch data[pos] {
+		case 0x21: // extension: label, then sub-blocks
+			pos += 2
+			if !skipSubBlocks() {
+				return 0, ErrUnsupported
+			}
+		case 0x2c: // image descriptor, then the LZW minimum code size and sub-blocks
+			if pos+10 > len(data) {
+				return 0, ErrUnsupported
+			}
+			width := int(binary.LittleEndian.Uint16(data[pos+5:]))
+			height := int(binary.LittleEndian.Uint16(data[pos+7:]))
+			flags := data[pos+9]
+			pixels += width * height
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			pos++
+			if !skipSubBlocks() {
+				return 0, ErrUnsupported
+			}
+		case 0x3b: // trailer
+			return pixels, nil
+		default:
+			return 0, ErrUnsupported
+		}
+	}
+	return pixels, nil
+}
+
+// stripWebP removes EXIF and XMP chunks from a RIFF WebP container and
+// clears the matching flags in the VP8X header.
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, ErrUnsupported
+	}
+
+	out := []byte("RIFF\x00\x00\x00\x00WEBP")
+	for rest := data[12:]; len(rest) > 0; {
+		if len(rest) < 8 {
+			return nil, ErrUnsupported
+		}
+		fourCC := string(rest[0:4])
+		size := int(binary.LittleEndian.Uint32(rest[4:8]))
+		padded := size + size%2
+		if padded > len(rest)-8 {
+			return nil, ErrUnsupported
+		}
+		chunk := slices.Clone(rest[:8+padded])
+		rest = rest[8+padded:]
+
+		switch fourCC {
+		case "EXIF", "XMP ":
+			continue
+		case "VP8X":
+			if size > 0 {
+				chunk[8] &^= 0x08 | 0x04
+			}
+		}
+		out = append(out, chunk...)
+	}
+
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out, nil
+}
@@ -0,0 +1,207 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"testing"
+)
+
+func encodeGIF(t *testing.T, frames, width, height int) []byte {
+	t.Helper()
+	p := color.Palette(palette.Plan9[:2])
+	g := &gif.GIF{Config: image.Config{ColorModel: p, Width: width, Height: height}}
+	for range frames {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, width, height), p))
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// descriptorsOnly builds a GIF whose frames claim width x height pixels
+// but carry no image data, which is cheap however large they claim to be.
+func descriptorsOnly(frames, width, height int) []byte {
+	data := []byte("GIF89a")
+	data = binary.LittleEndian.AppendUint16(data, uint16(width))
+	data = binary.LittleEndian.AppendUint16(data, uint16(height))
+	data = append(data, 0, 0, 0)
+	for range frames {
+		data = append(data, 0x2c, 0, 0, 0, 0)
+		data = binary.LittleEndian.AppendUint16(data, uint16(width))
+		data = binary.LittleEndian.AppendUint16(data, uint16(height))
+		data = append(data, 0, 2, 0)
+	}
+	return append(data, 0x3b)
+}
+
+func TestGIFPixels(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"encoded", encodeGIF(t, 3, 20, 10), 600},
+		{"descriptors", descriptorsOnly(4, MaxDimension, MaxDimension), 4 * MaxDimension * MaxDimension},
+		{"no frames", descriptorsOnly(0, 1, 1), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := gifPixels(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("gifPixels = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeGIF(t *testing.T) {
+	animation := encodeGIF(t, 20, 64, 64)
+
+	tests := []struct {
+		name   string
+		data   []byte
+		frames int
+		err    error
+	}{
+		{"single frame", encodeGIF(t, 1, 64, 64), 1, nil},
+		{"animation", animation, 20, nil},
+		{"large frames over the budget", descriptorsOnly(5, MaxDimension, MaxDimension), 0, ErrFrames},
+		{"small frames over the budget", descriptorsOnly(MaxGIFPixels/(16*16)+1, 16, 16), 0, ErrFrames},
+		{"truncated", animation[:len(animation)/2], 0, ErrUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Sanitize(tt.data)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Sanitize error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			g, err := gif.DecodeAll(bytes.NewReader(out))
+			if err != nil {
+				t.Fatalf("decoding sanitized GIF: %v", err)
+			}
+			if len(g.Image) != tt.frames {
+				t.Errorf("sanitized GIF has %d frames, want %d", len(g.Image), tt.frames)
+			}
+		})
+	}
+}
+
+// quadrants is a JPEG whose quarters are red, green, blue and white,
+// reading left to right and top to bottom.
+func quadrants(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	for y := range 32 {
+		for x := range 64 {
+			img.Set(x, y, quadrantColors[y/16*2+x/32])
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var quadrantColors = []color.RGBA{
+	{255, 0, 0, 255}, {0, 255, 0, 255},
+	{0, 0, 255, 255}, {255, 255, 255, 255},
+}
+
+// withOrientation inserts an EXIF segment holding only the Orientation
+// tag after the start of a JPEG file, as cameras write it.
+func withOrientation(data []byte, order binary.AppendByteOrder, orientation uint16) []byte {
+	tiff := []byte("II")
+	if order == binary.AppendByteOrder(binary.BigEndian) {
+		tiff = []byte("MM")
+	}
+	tiff = order.AppendUint16(tiff, 42)
+	tiff = order.AppendUint32(tiff, 8)
+	tiff = order.AppendUint16(tiff, 1)
+	tiff = order.AppendUint16(tiff, 0x0112) // Orientation
+	tiff = order.AppendUint16(tiff, 3)      // SHORT
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0)
+	tiff = order.AppendUint32(tiff, 0) // no next IFD
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	out := append([]byte{0xff, 0xd8, 0xff, 0xe1}, byte((len(segment)+2)>>8), byte(len(segment)+2))
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func TestSanitizeJPEGOrientation(t *testing.T) {
+	src := quadrants(t)
+	red, green, blue, white := quadrantColors[0], quadrantColors[1], quadrantColors[2], quadrantColors[3]
+
+	tests := []struct {
+		name          string
+		data          []byte
+		width, height int
+		corners       [4]color.RGBA // top left, top right, bottom left, bottom right
+	}{
+		{"no EXIF", src, 64, 32, [4]color.RGBA{red, green, blue, white}},
+		{"upright", withOrientation(src, binary.LittleEndian, 1), 64, 32, [4]color.RGBA{red, green, blue, white}},
+		{"mirrored", withOrientation(src, binary.LittleEndian, 2), 64, 32, [4]color.RGBA{green, red, white, blue}},
+		{"upside down", withOrientation(src, binary.LittleEndian, 3), 64, 32, [4]color.RGBA{white, blue, green, red}},
+		{"turn clockwise", withOrientation(src, binary.LittleEndian, 6), 32, 64, [4]color.RGBA{blue, red, white, green}},
+		{"turn clockwise, big endian", withOrientation(src, binary.BigEndian, 6), 32, 64, [4]color.RGBA{blue, red, white, green}},
+		{"turn counterclockwise", withOrientation(src, binary.LittleEndian, 8), 32, 64, [4]color.RGBA{green, white, red, blue}},
+		{"transposed", withOrientation(src, binary.LittleEndian, 5), 32, 64, [4]color.RGBA{red, blue, green, white}},
+		{"transversed", withOrientation(src, binary.LittleEndian, 7), 32, 64, [4]color.RGBA{white, green, blue, red}},
+		{"out of range", withOrientation(src, binary.LittleEndian, 9), 64, 32, [4]color.RGBA{red, green, blue, white}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Sanitize(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if o := jpegOrientation(out); o != 1 {
+				t.Errorf("sanitized JPEG keeps orientation %d", o)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := img.Bounds()
+			if b.Dx() != tt.width || b.Dy() != tt.height {
+				t.Fatalf("size = %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.width, tt.height)
+			}
+			// Sample a few pixels in from each corner, away from JPEG
+			// artefacts at the quadrant edges.
+			points := []image.Point{{4, 4}, {b.Dx() - 5, 4}, {4, b.Dy() - 5}, {b.Dx() - 5, b.Dy() - 5}}
+			for i, p := range points {
+				if !near(img.At(p.X, p.Y), tt.corners[i]) {
+					t.Errorf("pixel at %v = %v, want about %v", p, img.At(p.X, p.Y), tt.corners[i])
+				}
+			}
+		})
+	}
+}
+
+func near(c color.Color, want color.RGBA) bool {
+	r, g, b, _ := c.RGBA()
+	for _, d := range []int{int(r>>8) - int(want.R), int(g>>8) - int(want.G), int(b>>8) - int(want.B)} {
+		if d < -24 || d > 24 {
+			return false
+		}
+	}
+	return true
+}
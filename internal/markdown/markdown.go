@@ -0,0 +1,138 @@
+package markdown
+
+import (
+	"bytes"
+	"log/slog"
+	"regexp"
+	"unicode"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Rel is set on every link written by a user, so search engines do not
+// reward spam and can tell the link apart from editorial content.
+const Rel = "nofollow ugc"
+
+var (
+	mentionPattern = regexp.MustCompile(`^@(\d*[a-zA-Z][a-zA-Z0-9]*)`)
+	topicPattern   = regexp.MustCompile(`^#([a-zA-Z0-9]+)`)
+)
+
+var md = goldmark.New(
+	goldmark.WithExtensions(extension.Strikethrough),
+	goldmark.WithParserOptions(
+		parser.WithInlineParsers(
+			util.Prioritized(&referenceParser{}, 500),
+		),
+		parser.WithASTTransformers(
+			util.Prioritized(&relTransformer{}, 100),
+		),
+	),
+)
+
+var policy = newPolicy()
+
+// newPolicy allow-lists the HTML that the CommonMark subset and the
+// strikethrough extension can produce.
+// Anything else, including raw HTML typed into the source, is dropped.
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements(
+		"p", "br", "hr", "em", "strong", "del", "code", "pre", "blockquote",
+		"ul", "ol", "li", "h1", "h2", "h3", "h4", "h5", "h6",
+	)
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowAttrs("rel").Matching(regexp.MustCompile(`^` + Rel + `$`)).OnElements("a")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^(mention|topic)$`)).OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.AllowRelativeURLs(true)
+	return p
+}
+
+// Render converts the markdown source of a post or comment into sanitized
+// HTML that clients can insert into the page as-is. It runs when posts and
+// comments are written, and the result is stored next to the source;
+// changes to what it produces only reach rows written afterwards, unless a
+// migration clears the stored HTML so it is rendered again when read.
+func Render(source string) string {
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(source), &buf); err != nil {
+		slog.Error("Failed to render markdown", "err", err)
+		return policy.Sanitize(source)
+	}
+	return policy.SanitizeReader(&buf).String()
+}
+
+// referenceParser turns @username into a link to the user's profile and
+// #topic into a link to the topic, matching the frontend's routes.
+type referenceParser struct{}
+
+func (p *referenceParser) Trigger() []byte {
+	return []byte{'@', '#'}
+}
+
+func (p *referenceParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	if pc.IsInLinkLabel() {
+		return nil
+	}
+	if prev := block.PrecendingCharacter(); prev != '\n' && !unicode.IsSpace(prev) && !unicode.IsPunct(prev) {
+		return nil
+	}
+
+	line, segment := block.PeekLine()
+
+	var (
+		match []int
+		dest  string
+		class string
+	)
+	if line[0] == '@' {
+		match = mentionPattern.FindSubmatchIndex(line)
+		class = "mention"
+		if match != nil {
+			dest = "/user/" + string(line[match[2]:match[3]])
+		}
+	} else {
+		match = topicPattern.FindSubmatchIndex(line)
+		class = "topic"
+		if match != nil {
+			dest = "/topics/" + string(line[match[2]:match[3]])
+		}
+	}
+	if match == nil {
+		return nil
+	}
+
+	link := ast.NewLink()
+	link.Destination = []byte(dest)
+	link.SetAttributeString("class", []byte(class))
+	link.AppendChild(link, ast.NewTextSegment(segment.WithStop(segment.Start+match[1])))
+	block.Advance(match[1])
+	return link
+}
+
+// relTransformer marks links written by users with Rel. Links produced by
+// referenceParser point inside the site and are left alone.
+type relTransformer struct{}
+
+func (t *relTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n.(type) {
+		case *ast.Link, *ast.AutoLink:
+			if _, internal := n.AttributeString("class"); !internal {
+				n.SetAttributeString("rel", []byte(Rel))
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+}
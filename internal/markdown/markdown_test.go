@@ -0,0 +1,51 @@
+package markdown
+
+import "testing"
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"script block", "<script>alert(1)</script>", "\n"},
+		{"inline script", "hello <script>alert(1)</script>", "<p>hello alert(1)</p>\n"},
+		{"event handler", `<img src=x onerror=alert(1)>`, "\n"},
+		{"raw link", `<a href="https://example.com" onclick="x()">y</a>`, "<p>y</p>\n"},
+		{"javascript link", "[x](javascript:alert(1))", `<p><a rel="nofollow ugc">x</a></p>` + "\n"},
+		{"mixed case scheme", "[x](JaVaScRiPt:alert(1))", `<p><a rel="nofollow ugc">x</a></p>` + "\n"},
+		{"data link", "[x](data:text/html;base64,PHNjcmlwdD4=)", `<p><a rel="nofollow ugc">x</a></p>` + "\n"},
+		{"image", "![x](https://example.com/a.png)", "<p></p>\n"},
+		{"code", "`<b>`", "<p><code>&lt;b&gt;</code></p>\n"},
+		{
+			"link",
+			`[x](https://example.com "title")`,
+			`<p><a href="https://example.com" rel="nofollow ugc">x</a></p>` + "\n",
+		},
+		{
+			"autolink",
+			"<https://example.com>",
+			`<p><a href="https://example.com" rel="nofollow ugc">https://example.com</a></p>` + "\n",
+		},
+		{"strikethrough", "~~gone~~", "<p><del>gone</del></p>\n"},
+		{"ordered list", "3. three", `<ol start="3">` + "\n<li>three</li>\n</ol>\n"},
+		{
+			"references",
+			"hi @alice and #golang",
+			`<p>hi <a href="/user/alice" class="mention">@alice</a> and <a href="/topics/golang" class="topic">#golang</a></p>` + "\n",
+		},
+		{"email", "mail@alice.com", "<p>mail@alice.com</p>\n"},
+		{
+			"mention in link",
+			"[@bob](https://example.com)",
+			`<p><a href="https://example.com" rel="nofollow ugc">@bob</a></p>` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.source); got != tt.want {
+				t.Errorf("Render(%q) = %q, want %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
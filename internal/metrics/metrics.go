@@ -0,0 +1,96 @@
+// Package metrics holds the Prometheus collectors the API exports on
+// /metrics. They live in a registry of their own rather than the global
+// one, so only what is registered here is exposed.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "forum"
+
+var registry = prometheus.NewRegistry()
+
+var (
+	// Requests counts handled requests by method, route pattern and status.
+	Requests = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests handled, by method, route pattern and status code.",
+	}, []string{"method", "route", "status"}))
+
+	// RequestDuration observes how long requests took, by method, route
+	// pattern and status.
+	RequestDuration = register(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by method, route pattern and status code.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"method", "route", "status"}))
+
+	// RateLimited counts requests refused by a rate limit, by policy.
+	RateLimited = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "rate_limited_total",
+		Help:      "Requests refused by a rate limit, by policy.",
+	}, []string{"policy"}))
+
+	// Logins counts successful logins. new_user is "true" when the login
+	// created the account.
+	Logins = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Successful logins, by whether they created the account.",
+	}, []string{"new_user"}))
+
+	PostsCreated = register(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "posts_created_total",
+		Help:      "Posts created.",
+	}))
+
+	CommentsCreated = register(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "comments_created_total",
+		Help:      "Comments created.",
+	}))
+
+	// Votes counts votes cast on posts. direction is "up", "down" or
+	// "cleared".
+	Votes = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "votes_cast_total",
+		Help:      "Votes cast on posts, by direction.",
+	}, []string{"direction"}))
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+func register[C prometheus.Collector](c C) C {
+	registry.MustRegister(c)
+	return c
+}
+
+// RegisterDB exports the connection pool statistics of conn, as reported
+// by conn.Stats, at every scrape.
+func RegisterDB(conn *sql.DB) {
+	registry.MustRegister(collectors.NewDBStatsCollector(conn, "postgres"))
+}
+
+// Handler serves the registered metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -1,27 +1,105 @@
 package middleware
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"backend/internal/auth"
+	"backend/internal/logging"
+	"backend/internal/problem"
+	"backend/internal/store"
 )
 
-func Auth(next http.Handler) http.Handler {
+var errInvalidToken = problem.New(http.StatusUnauthorized, "invalid_token", "Invalid token.")
+
+// Auth rejects requests without a valid token, and those of users who have
+// since been deleted or banned. Bans and deletions take effect within
+// banCacheTTL.
+func Auth(db *sql.DB, tokens *auth.Tokens, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		header := r.Header.Get("Authorization")
 		if header == "" {
-			http.Error(w, "Missing Token", http.StatusUnauthorized)
+			problem.Write(w, r, problem.New(http.StatusUnauthorized, "missing_token", "Missing token."))
 			return
 		}
 
 		tokenStr := strings.TrimPrefix(header, "Bearer ")
-		_, err := auth.VerifyToken(tokenStr)
+		userID, err := tokens.Verify(tokenStr)
 		if err != nil {
-			http.Error(w, "Invalid Token", http.StatusUnauthorized)
+			problem.Write(w, r, errInvalidToken)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		status, err := bans.lookup(r.Context(), db, userID)
+		if err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		} else if status.deleted {
+			problem.Write(w, r, errInvalidToken)
+			return
+		} else if status.banned {
+			problem.Write(w, r, auth.Banned(status.reason))
+			return
+		}
+
+		ctx := auth.NewContext(logging.WithUser(r.Context(), userID), userID)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// banCacheTTL is how long Auth relies on a user's ban status before it
+// reads it again, sparing the database a query on every request.
+const banCacheTTL = 30 * time.Second
+
+var bans = banCache{entries: map[int]banStatus{}}
+
+type banStatus struct {
+	banned  bool
+	reason  string
+	deleted bool
+	expires time.Time
+}
+
+// banCache holds the ban status of the users seen in the last banCacheTTL.
+type banCache struct {
+	mu      sync.Mutex
+	entries map[int]banStatus
+}
+
+func (c *banCache) lookup(ctx context.Context, db *sql.DB, userID int) (banStatus, error) {
+	now := time.Now()
+	c.mu.Lock()
+	s, ok := c.entries[userID]
+	c.mu.Unlock()
+	if ok && now.Before(s.expires) {
+		return s, nil
+	}
+
+	var err error
+	s = banStatus{expires: now.Add(banCacheTTL)}
+	s.banned, s.reason, err = store.BanStatus(ctx, db, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		s.deleted = true
+	} else if err != nil {
+		return banStatus{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Drop expired entries now and then, so users who stopped making
+	// requests do not stay in memory.
+	if len(c.entries) >= 10000 {
+		for id, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, id)
+			}
+		}
+	}
+	c.entries[userID] = s
+	return s, nil
+}
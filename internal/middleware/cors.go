@@ -1,16 +1,39 @@
 package middleware
 
-import "net/http"
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"backend/internal/origin"
+)
+
+// CORS lets browsers on the given origins call the API, and no others.
+// Origins are parsed as origin.Parse does, so they may have wildcards.
+// Browsers may cache the answer to a preflight request for maxAge.
+func CORS(origins []string, maxAge time.Duration, next http.Handler) http.Handler {
+	var allowed []origin.Pattern
+	for _, o := range origins {
+		if p, ok := origin.Parse(o); ok {
+			allowed = append(allowed, p)
+		}
+	}
 
-func CORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get("Origin")
+		from := r.Header.Get("Origin")
+
+		// Responses differ by origin, so caches must not share them.
+		w.Header().Add("Vary", "Origin")
 
-		if origin != "" {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-			w.Header().Set("Vary", "Origin")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		if from != "" && slices.ContainsFunc(allowed, func(p origin.Pattern) bool { return p.Matches(from) }) {
+			w.Header().Set("Access-Control-Allow-Origin", from)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Expose-Headers", "Location, ETag, Deprecation, Link, X-Request-ID, Idempotent-Replayed, Retry-After, RateLimit-Policy, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID, Idempotency-Key, If-Match")
+			if r.Method == http.MethodOptions && maxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(maxAge.Seconds())))
+			}
 		}
 
 		if r.Method == http.MethodOptions {
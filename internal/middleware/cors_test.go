@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	origins := []string{"https://forum.example.com", "https://*.preview.example.com", "not an origin"}
+	h := CORS(origins, 10*time.Minute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	tests := []struct {
+		name        string
+		method      string
+		origin      string
+		wantAllowed bool
+		wantStatus  int
+		wantMaxAge  string
+	}{
+		{"listed", "GET", "https://forum.example.com", true, http.StatusTeapot, ""},
+		{"subdomain of listed", "GET", "https://app.forum.example.com", false, http.StatusTeapot, ""},
+		{"wildcard", "GET", "https://pr-12.preview.example.com", true, http.StatusTeapot, ""},
+		{"nested wildcard", "GET", "https://a.pr-12.preview.example.com", true, http.StatusTeapot, ""},
+		{"wildcard parent", "GET", "https://preview.example.com", false, http.StatusTeapot, ""},
+		{"wildcard lookalike", "GET", "https://evilpreview.example.com", false, http.StatusTeapot, ""},
+		{"wildcard wrong scheme", "GET", "http://pr-12.preview.example.com", false, http.StatusTeapot, ""},
+		{"unparsable entry", "GET", "not an origin", false, http.StatusTeapot, ""},
+		{"no origin", "GET", "", false, http.StatusTeapot, ""},
+		{"preflight", "OPTIONS", "https://pr-12.preview.example.com", true, http.StatusNoContent, "600"},
+		{"refused preflight", "OPTIONS", "https://example.org", false, http.StatusNoContent, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/v1/posts", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			wantOrigin := ""
+			if tt.wantAllowed {
+				wantOrigin = tt.origin
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Max-Age"); got != tt.wantMaxAge {
+				t.Errorf("Access-Control-Max-Age = %q, want %q", got, tt.wantMaxAge)
+			}
+			if got := w.Header().Get("Vary"); got != "Origin" {
+				t.Errorf("Vary = %q, want Origin", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+)
+
+// Deprecated marks responses from a legacy route, pointing clients at the
+// /api/v1 surface that replaces it.
+func Deprecated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", `</api/v1>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LegacyStatus answers with the status code a legacy route used before the
+// handler behind it was shared with /api/v1: every 2xx status becomes
+// success, and so do the errors in ignored, which the route used to treat
+// as nothing to do. The bodies of ignored errors are dropped.
+func LegacyStatus(success int, ignored []int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&legacyWriter{ResponseWriter: w, success: success, ignored: ignored}, r)
+	})
+}
+
+type legacyWriter struct {
+	http.ResponseWriter
+	success     int
+	ignored     []int
+	wroteHeader bool
+	discard     bool
+}
+
+func (lw *legacyWriter) WriteHeader(status int) {
+	if !lw.wroteHeader {
+		lw.wroteHeader = true
+		switch {
+		case status >= 200 && status < 300:
+			status = lw.success
+		case slices.Contains(lw.ignored, status):
+			status, lw.discard = lw.success, true
+			lw.Header().Del("Content-Type")
+			lw.Header().Del("Content-Length")
+		}
+	}
+	lw.ResponseWriter.WriteHeader(status)
+}
+
+func (lw *legacyWriter) Write(b []byte) (int, error) {
+	if !lw.wroteHeader {
+		lw.WriteHeader(http.StatusOK)
+	}
+	if lw.discard {
+		return len(b), nil
+	}
+	return lw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (lw *legacyWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
@@ -0,0 +1,254 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/logging"
+	"backend/internal/problem"
+)
+
+const (
+	IdempotencyHeader = "Idempotency-Key"
+
+	maxIdempotencyKeyLength = 255
+)
+
+// Idempotency makes POST, PATCH and DELETE requests that carry an
+// Idempotency-Key header safe to retry. The first request with a key is
+// handled normally and its response stored; repeats within ttl get the
+// stored response back instead of running the handler again. Reusing a key
+// for a different request is rejected, as is a repeat that arrives while
+// the first request is still running. A request holds its key for lease
+// at most: a repeat arriving later runs the handler again, so retries are
+// not blocked by a request that was lost when the server stopped. lease
+// must therefore be longer than any request may take.
+//
+// Keys belong to the user the request's token names. Requests without a
+// valid token are passed through without using their key, as they cannot
+// change anything and anonymous callers would otherwise share keys.
+//
+// Only responses that a retry would get again are stored: successes and
+// client errors other than those caused by timing or other requests, such
+// as 409 Conflict and 429 Too Many Requests. After any other response the
+// key is released, so a retry runs the handler again. Responses marked
+// Cache-Control: no-store, such as the token from a login, are never
+// stored either.
+func Idempotency(db *sql.DB, tokens *auth.Tokens, ttl, lease time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyHeader)
+		if key == "" || !slices.Contains([]string{http.MethodPost, http.MethodPatch, http.MethodDelete}, r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, "invalid_idempotency_key",
+				"Idempotency-Key must be at most "+strconv.Itoa(maxIdempotencyKeyLength)+" characters."))
+			return
+		}
+
+		// Keys are per user, so one client cannot replay another's response.
+		userID, err := tokens.Verify(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				problem.Write(w, r, problem.New(http.StatusRequestEntityTooLarge, "body_too_large", "Request body is too large."))
+			} else {
+				problem.Write(w, r, problem.New(http.StatusBadRequest, "invalid_body", "Could not read request body."))
+			}
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := requestHash(r, body)
+
+		// Claim the key, taking over expired entries so they need not wait
+		// for the cleanup job, and claims whose lease ran out. The time of
+		// the claim tells it apart from a later one that took it over.
+		var claimedAt time.Time
+		err = db.QueryRowContext(r.Context(),
+			`INSERT INTO idempotency_keys (user_id, key, request_hash) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, key) DO UPDATE
+				SET request_hash = EXCLUDED.request_hash, status = NULL, headers = NULL, body = NULL, created_at = now()
+				WHERE idempotency_keys.created_at < now() - make_interval(secs => $4)
+					OR (idempotency_keys.status IS NULL AND idempotency_keys.created_at < now() - make_interval(secs => $5))
+			RETURNING created_at`,
+			userID,
+			key,
+			hash,
+			ttl.Seconds(),
+			lease.Seconds(),
+		).Scan(&claimedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			replay(w, r, db, userID, key, hash)
+			return
+		} else if err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+
+		before := w.Header().Clone()
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// The request may have been cancelled by now, but its response was
+		// sent and must be kept.
+		ctx := context.WithoutCancel(r.Context())
+		if !storable(rec.status) || w.Header().Get("Cache-Control") == "no-store" {
+			_, err = db.ExecContext(ctx,
+				`DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND created_at = $3`,
+				userID,
+				key,
+				claimedAt,
+			)
+		} else {
+			headers, _ := json.Marshal(addedHeaders(before, w.Header()))
+			_, err = db.ExecContext(ctx,
+				`UPDATE idempotency_keys SET status = $4, headers = $5, body = $6
+				WHERE user_id = $1 AND key = $2 AND created_at = $3`,
+				userID,
+				key,
+				claimedAt,
+				rec.status,
+				headers,
+				rec.body.Bytes(),
+			)
+		}
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed to store idempotent response", "err", err)
+		}
+	})
+}
+
+// storable reports whether every retry of a request would get a response
+// with status, so that it can be replayed.
+func storable(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusPreconditionFailed,
+		http.StatusLocked, http.StatusTooEarly, http.StatusTooManyRequests:
+		return false
+	}
+	return status >= 200 && status < 300 || status >= 400 && status < 500
+}
+
+// replay answers a repeated request from the stored response.
+func replay(w http.ResponseWriter, r *http.Request, db *sql.DB, userID int, key, hash string) {
+	var (
+		storedHash string
+		status     sql.NullInt64
+		headers    []byte
+		body       []byte
+	)
+	err := db.QueryRowContext(r.Context(),
+		`SELECT request_hash, status, headers, body FROM idempotency_keys WHERE user_id = $1 AND key = $2`,
+		userID,
+		key,
+	).Scan(&storedHash, &status, &headers, &body)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The first request failed and released the key in the meantime.
+		problem.Write(w, r, errIdempotencyInProgress)
+		return
+	} else if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+
+	if storedHash != hash {
+		problem.Write(w, r, problem.New(http.StatusUnprocessableEntity, "idempotency_key_reused",
+			"Idempotency-Key was already used for a different request."))
+		return
+	}
+	if !status.Valid {
+		w.Header().Set("Retry-After", "1")
+		problem.Write(w, r, errIdempotencyInProgress)
+		return
+	}
+
+	var h http.Header
+	json.Unmarshal(headers, &h)
+	maps.Copy(w.Header(), h)
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(int(status.Int64))
+	w.Write(body)
+}
+
+var errIdempotencyInProgress = problem.New(http.StatusConflict, "idempotency_key_in_progress",
+	"A request with this Idempotency-Key is still being processed.")
+
+// requestHash fingerprints what a key was first used for, so reusing it
+// for another request can be detected.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// addedHeaders returns the headers set while handling the request, leaving
+// out those that outer middleware sets for every request anyway.
+func addedHeaders(before, after http.Header) http.Header {
+	added := http.Header{}
+	for k, v := range after {
+		if !slices.Equal(before[k], v) {
+			added[k] = v
+		}
+	}
+	return added
+}
+
+// PruneIdempotencyKeys deletes stored responses older than ttl.
+func PruneIdempotencyKeys(db *sql.DB, ttl time.Duration) (int64, error) {
+	res, err := db.Exec(
+		`DELETE FROM idempotency_keys WHERE created_at < now() - make_interval(secs => $1)`,
+		ttl.Seconds(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// recorder passes a response through while keeping a copy of it.
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *recorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,262 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/internal/auth"
+)
+
+// keysDB stands in for Postgres, holding the idempotency_keys table and
+// answering the statements Idempotency runs against it.
+type keysDB struct {
+	mu   sync.Mutex
+	rows map[string]*keyRow // by user ID and key
+}
+
+type keyRow struct {
+	hash          string
+	status        any // nil while the request runs
+	headers, body []byte
+	createdAt     time.Time
+}
+
+func (k *keysDB) Connect(context.Context) (driver.Conn, error) { return k, nil }
+func (k *keysDB) Driver() driver.Driver                        { return nil }
+func (k *keysDB) Prepare(string) (driver.Stmt, error)          { return nil, errors.New("not supported") }
+func (k *keysDB) Close() error                                 { return nil }
+func (k *keysDB) Begin() (driver.Tx, error)                    { return nil, errors.New("not supported") }
+
+func (k *keysDB) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	id := rowID(args[0].Value.(int64), args[1].Value.(string))
+	now := time.Now()
+	switch {
+	case strings.HasPrefix(query, "INSERT INTO idempotency_keys"):
+		ttl := time.Duration(args[3].Value.(float64) * float64(time.Second))
+		lease := time.Duration(args[4].Value.(float64) * float64(time.Second))
+		if old, ok := k.rows[id]; ok && old.createdAt.After(now.Add(-ttl)) &&
+			(old.status != nil || old.createdAt.After(now.Add(-lease))) {
+			return &rows{columns: []string{"created_at"}}, nil
+		}
+		k.rows[id] = &keyRow{hash: args[2].Value.(string), createdAt: now}
+		return &rows{columns: []string{"created_at"}, values: [][]driver.Value{{now}}}, nil
+	case strings.HasPrefix(query, "SELECT request_hash, status, headers, body"):
+		r := &rows{columns: []string{"request_hash", "status", "headers", "body"}}
+		if row, ok := k.rows[id]; ok {
+			r.values = [][]driver.Value{{row.hash, row.status, row.headers, row.body}}
+		}
+		return r, nil
+	}
+	return nil, errors.New("unexpected query: " + query)
+}
+
+func (k *keysDB) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	id := rowID(args[0].Value.(int64), args[1].Value.(string))
+	row, ok := k.rows[id]
+	if !ok || !row.createdAt.Equal(args[2].Value.(time.Time)) {
+		return driver.RowsAffected(0), nil
+	}
+	switch {
+	case strings.HasPrefix(query, "DELETE FROM idempotency_keys"):
+		delete(k.rows, id)
+	case strings.HasPrefix(query, "UPDATE idempotency_keys"):
+		row.status, row.headers, row.body = args[3].Value, args[4].Value.([]byte), args[5].Value.([]byte)
+	default:
+		return nil, errors.New("unexpected statement: " + query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func rowID(userID int64, key string) string {
+	return strconv.FormatInt(userID, 10) + "/" + key
+}
+
+// claim stores a claim of key made age ago by a request that has not
+// finished, for a POST to /v1/posts with body.
+func (k *keysDB) claim(key, body string, age time.Duration) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	r := httptest.NewRequest(http.MethodPost, "/v1/posts", nil)
+	k.rows[rowID(1, key)] = &keyRow{hash: requestHash(r, []byte(body)), createdAt: time.Now().Add(-age)}
+}
+
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestIdempotency(t *testing.T) {
+	const lease = time.Minute
+	keys := &keysDB{rows: map[string]*keyRow{}}
+	db := sql.OpenDB(keys)
+	tokens := auth.New("test secret", time.Hour)
+	token, err := tokens.Generate(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu    sync.Mutex
+		calls int
+		block = map[string]chan struct{}{} // bodies whose handling waits
+	)
+	h := Idempotency(db, tokens, 24*time.Hour, lease, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		calls++
+		n, wait := calls, block[string(body)]
+		mu.Unlock()
+		if wait != nil {
+			<-wait
+		}
+		if string(body) == "invalid" {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.Header().Set("Location", "/v1/posts/"+strconv.Itoa(n))
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, string(body))
+	}))
+
+	send := func(key, body string, signedIn bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/posts", strings.NewReader(body))
+		r.Header.Set(IdempotencyHeader, key)
+		if signedIn {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	handled := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls
+	}
+
+	tests := []struct {
+		name       string
+		key, body  string
+		signedIn   bool
+		setup      func()
+		wantStatus int
+		wantBody   string
+		replayed   bool
+		runs       bool // whether the handler runs
+	}{
+		{name: "claim", key: "a", body: "first", signedIn: true,
+			wantStatus: http.StatusCreated, wantBody: "first", runs: true},
+		{name: "replay", key: "a", body: "first", signedIn: true,
+			wantStatus: http.StatusCreated, wantBody: "first", replayed: true},
+		{name: "different request", key: "a", body: "second", signedIn: true,
+			wantStatus: http.StatusUnprocessableEntity},
+		{name: "anonymous", key: "a", body: "first",
+			wantStatus: http.StatusCreated, wantBody: "first", runs: true},
+		{name: "conflict is not stored", key: "b", body: "invalid", signedIn: true,
+			wantStatus: http.StatusConflict, runs: true},
+		{name: "retry after conflict", key: "b", body: "invalid", signedIn: true,
+			wantStatus: http.StatusConflict, runs: true},
+		{name: "in progress", key: "c", body: "first", signedIn: true,
+			setup:      func() { keys.claim("c", "first", lease/2) },
+			wantStatus: http.StatusConflict},
+		{name: "in progress, different request", key: "d", body: "first", signedIn: true,
+			setup:      func() { keys.claim("d", "other", lease/2) },
+			wantStatus: http.StatusUnprocessableEntity},
+		{name: "lease ran out", key: "e", body: "first", signedIn: true,
+			setup:      func() { keys.claim("e", "first", 2*lease) },
+			wantStatus: http.StatusCreated, wantBody: "first", runs: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setup != nil {
+				tt.setup()
+			}
+			before := handled()
+			w := send(tt.key, tt.body, tt.signedIn)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body, tt.wantBody)
+			}
+			if got := w.Header().Get("Idempotent-Replayed") == "true"; got != tt.replayed {
+				t.Errorf("replayed = %v, want %v", got, tt.replayed)
+			}
+			if ran := handled() > before; ran != tt.runs {
+				t.Errorf("handler ran = %v, want %v", ran, tt.runs)
+			}
+		})
+	}
+
+	t.Run("replay keeps headers", func(t *testing.T) {
+		first := send("f", "headers", true)
+		again := send("f", "headers", true)
+		if loc := again.Header().Get("Location"); loc == "" || loc != first.Header().Get("Location") {
+			t.Errorf("replayed Location = %q, want %q", loc, first.Header().Get("Location"))
+		}
+	})
+
+	// A request whose claim was taken over must not overwrite the response
+	// of the retry that took it.
+	t.Run("taken over", func(t *testing.T) {
+		release := make(chan struct{})
+		mu.Lock()
+		block["slow"] = release
+		mu.Unlock()
+
+		done := make(chan *httptest.ResponseRecorder)
+		go func() { done <- send("g", "slow", true) }()
+		id := rowID(1, "g")
+		for {
+			keys.mu.Lock()
+			row, ok := keys.rows[id]
+			if ok {
+				row.createdAt = row.createdAt.Add(-2 * lease)
+			}
+			keys.mu.Unlock()
+			if ok {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		mu.Lock()
+		delete(block, "slow")
+		mu.Unlock()
+		retry := send("g", "slow", true)
+		close(release)
+		<-done
+
+		replay := send("g", "slow", true)
+		if replay.Header().Get("Location") != retry.Header().Get("Location") {
+			t.Errorf("replayed Location = %q, want the retry's %q",
+				replay.Header().Get("Location"), retry.Header().Get("Location"))
+		}
+	})
+}
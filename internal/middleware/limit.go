@@ -0,0 +1,12 @@
+package middleware
+
+import "net/http"
+
+// LimitBody caps request bodies at n bytes. Reading past the limit fails
+// with an *http.MaxBytesError.
+func LimitBody(n int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+		next.ServeHTTP(w, r)
+	})
+}
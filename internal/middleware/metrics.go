@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/internal/metrics"
+)
+
+// Metrics counts and times requests by the route RecordRoute reports, and
+// labels requests that matched no route "unmatched" so arbitrary paths
+// cannot blow up the number of series.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx, matched := withRoute(r.Context())
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		route := *matched
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(sw.status)
+		method := methodLabel(r.Method)
+		metrics.Requests.WithLabelValues(method, route, status).Inc()
+		metrics.RequestDuration.WithLabelValues(method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// methodLabel folds methods other than the standard ones into "OTHER", as
+// the legacy routes accept any method.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backend/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/posts/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("POST /v1/posts", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/addpost", func(w http.ResponseWriter, r *http.Request) {})
+	h := Metrics(RecordRoute(mux))
+
+	tests := []struct {
+		method, path          string
+		wantRoute, wantStatus string
+		wantMethod            string
+	}{
+		{"GET", "/v1/posts/1", "GET /v1/posts/{id}", "200", "GET"},
+		{"GET", "/v1/posts/2", "GET /v1/posts/{id}", "200", "GET"},
+		{"POST", "/v1/posts", "POST /v1/posts", "201", "POST"},
+		{"GET", "/no/such/path", "unmatched", "404", "GET"},
+		{"DELETE", "/v1/posts/1", "unmatched", "405", "DELETE"},
+		{"BREW", "/addpost", "/addpost", "200", "OTHER"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			counter := metrics.Requests.WithLabelValues(tt.wantMethod, tt.wantRoute, tt.wantStatus)
+			before := testutil.ToFloat64(counter)
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("requests_total{method=%q,route=%q,status=%q} grew by %v, want 1",
+					tt.wantMethod, tt.wantRoute, tt.wantStatus, got)
+			}
+		})
+	}
+}
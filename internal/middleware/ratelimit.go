@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"backend/internal/auth"
+	"backend/internal/logging"
+	"backend/internal/metrics"
+	"backend/internal/problem"
+	"backend/internal/ratelimit"
+)
+
+// RateLimiter applies rate limit policies to individual routes.
+type RateLimiter struct {
+	store          ratelimit.Store
+	tokens         *auth.Tokens
+	trustedProxies []netip.Prefix
+}
+
+func NewRateLimiter(store ratelimit.Store, tokens *auth.Tokens, trustedProxies []netip.Prefix) *RateLimiter {
+	return &RateLimiter{store: store, tokens: tokens, trustedProxies: trustedProxies}
+}
+
+// Limit lets requests through to next while the caller's bucket for p has
+// tokens left, and answers 429 Too Many Requests otherwise. Signed-in
+// callers are limited per user, everyone else per client IP. Responses
+// carry the RateLimit-* headers of the IETF draft so clients can slow down
+// before they are refused.
+//
+// Requests are let through when the store fails, as refusing every write
+// would be worse than briefly not limiting them.
+func (l *RateLimiter) Limit(p ratelimit.Policy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, err := l.store.Take(r.Context(), l.key(r), p)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Rate limit check failed", "policy", p.Name, "err", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Policy", p.String())
+		h.Set("RateLimit-Limit", strconv.Itoa(p.Burst))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", ratelimit.Seconds(res.Reset))
+
+		if !res.Allowed {
+			metrics.RateLimited.WithLabelValues(p.Name).Inc()
+			h.Set("Retry-After", ratelimit.Seconds(res.RetryAfter))
+			problem.Write(w, r, problem.New(http.StatusTooManyRequests, "rate_limited",
+				"Too many requests; try again in "+ratelimit.Seconds(res.RetryAfter)+" seconds."))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// key identifies the caller: their user ID when they send a valid token,
+// else their IP address. IPv6 clients are keyed by /64, the smallest
+// network a single host usually gets.
+func (l *RateLimiter) key(r *http.Request) string {
+	if userID, err := l.tokens.Verify(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")); err == nil {
+		return "user:" + strconv.Itoa(userID)
+	}
+
+	ip := clientIP(r, l.trustedProxies)
+	if ip.Is6() {
+		prefix, _ := ip.Prefix(64)
+		return "ip:" + prefix.String()
+	}
+	return "ip:" + ip.String()
+}
+
+// clientIP returns the address of the client that sent r. Behind trusted
+// proxies it is the last address in X-Forwarded-For that was not added by
+// one of them; anything further left could have been sent by the client.
+func clientIP(r *http.Request, trusted []netip.Prefix) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip, _ := netip.ParseAddr(host)
+	ip = ip.Unmap()
+
+	isTrusted := func(ip netip.Addr) bool {
+		return slices.ContainsFunc(trusted, func(p netip.Prefix) bool { return p.Contains(ip) })
+	}
+	if !isTrusted(ip) {
+		return ip
+	}
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		ip = hop.Unmap()
+		if !isTrusted(ip) {
+			break
+		}
+	}
+	return ip
+}
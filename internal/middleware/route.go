@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+)
+
+type routeKey struct{}
+
+// withRoute returns a context carrying a place for RecordRoute to put the
+// matched pattern in, reusing the one ctx already carries.
+func withRoute(ctx context.Context) (context.Context, *string) {
+	if route, ok := ctx.Value(routeKey{}).(*string); ok {
+		return ctx, route
+	}
+	route := new(string)
+	return context.WithValue(ctx, routeKey{}, route), route
+}
+
+// RecordRoute wraps the ServeMux and hands the pattern it matched back to
+// the middleware further out, which only see copies of the request the
+// mux fills in r.Pattern on.
+func RecordRoute(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r)
+		if route, ok := r.Context().Value(routeKey{}).(*string); ok {
+			*route = r.Pattern
+		}
+	})
+}
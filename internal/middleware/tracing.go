@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"backend/internal/logging"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Trace starts a span for every request, continuing the trace named by a
+// traceparent header, and adds the trace ID to the request's logger. Once
+// the ServeMux has picked a route, the span is renamed after it, so spans
+// group by endpoint rather than by URL.
+func Trace(next http.Handler) http.Handler {
+	named := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		ctx, matched := withRoute(r.Context())
+		if sc := span.SpanContext(); sc.IsValid() {
+			ctx = logging.With(ctx, "trace_id", sc.TraceID().String())
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+
+		if pattern := *matched; pattern != "" {
+			method, route, ok := strings.Cut(pattern, " ")
+			if !ok {
+				method, route = r.Method, pattern
+			}
+			span.SetName(method + " " + route)
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+	})
+	return otelhttp.NewHandler(named, "request",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method
+		}),
+	)
+}
@@ -12,27 +12,44 @@ type Topic struct {
 	Description    string  `json:"description"`
 	ImageURL       *string `json:"imageUrl"`
 	ImageUpdatedAt int64   `json:"imageUpdatedAt,omitempty"`
+	Version        int     `json:"version"`
 }
 
 type Post struct {
-	ID               int    `json:"id"`
-	Title            string `json:"title"`
-	Body             string `json:"body"`
-	Topic            string `json:"topic"`
-	Creator          int    `json:"creator"`
-	CreatedAt        string `json:"created_at"`
-	IsEdited         bool   `json:"is_edited"`
-	Score            int    `json:"score"`
-	UserVote         int    `json:"user_vote,omitempty"`
-	ScoreWithoutUser int    `json:"score_without_user,omitempty"`
+	ID               int          `json:"id"`
+	Title            string       `json:"title"`
+	Body             string       `json:"body"`
+	BodyHTML         string       `json:"body_html"`
+	Topic            string       `json:"topic"`
+	Creator          int          `json:"creator"`
+	CreatedAt        string       `json:"created_at"`
+	IsEdited         bool         `json:"is_edited"`
+	Score            int          `json:"score"`
+	UserVote         int          `json:"user_vote,omitempty"`
+	ScoreWithoutUser int          `json:"score_without_user,omitempty"`
+	Attachments      []Attachment `json:"attachments"`
+	Version          int          `json:"version"`
+}
+
+type Attachment struct {
+	ID  int    `json:"id"`
+	URL string `json:"url"`
+	Alt string `json:"alt"`
 }
 
 type Comment struct {
 	ID        int    `json:"id"`
 	Body      string `json:"body"`
+	BodyHTML  string `json:"body_html"`
 	Post      int    `json:"post"`
 	Creator   int    `json:"creator"`
 	CreatedAt string `json:"created_at"`
 	IsEdited  bool   `json:"is_edited"`
 	Parent    *int   `json:"parent,omitempty"`
+	Version   int    `json:"version"`
+}
+
+type AccountDeletion struct {
+	RequestedAt string `json:"requested_at"`
+	DeletesAt   string `json:"deletes_at"`
 }
@@ -0,0 +1,143 @@
+// Package openapi serves the OpenAPI description of the API, and checks
+// that it still matches the routes and models it describes.
+package openapi
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+
+	swaggerui "github.com/swaggo/files/v2"
+)
+
+var (
+	//go:embed openapi.json
+	spec []byte
+
+	//go:embed docs.html docs.js
+	docs embed.FS
+)
+
+// docsCSP lets the docs page load only what Docs serves itself. Swagger UI
+// sets inline styles and shows its icons as data: URLs.
+const docsCSP = "default-src 'none'; script-src 'self'; style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data:; connect-src 'self'; base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
+
+// docsFiles are the files Docs serves, from the Swagger UI release pinned
+// in go.mod or from this package.
+var docsFiles = map[string]fs.FS{
+	"docs.html":            docs,
+	"docs.js":              docs,
+	"swagger-ui.css":       swaggerui.FS,
+	"swagger-ui-bundle.js": swaggerui.FS,
+}
+
+// Spec serves the OpenAPI document.
+func Spec() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	})
+}
+
+// Docs serves a page that renders the OpenAPI document with Swagger UI,
+// and the files it loads, which it takes from the {file} path value. The
+// page itself is served when there is none. Nothing is loaded from other
+// sites.
+func Docs() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("file")
+		if name == "" {
+			name = "docs.html"
+		}
+		fsys, ok := docsFiles[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Security-Policy", docsCSP)
+		http.ServeFileFS(w, r, fsys, name)
+	})
+}
+
+// operations are the keys of a path item that name operations, rather
+// than parameters and other properties shared by them.
+var operations = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+type document struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+// Check reports every route and model field that the document does not
+// describe, and every operation it describes that is not among routes.
+// Routes are ServeMux patterns such as "GET /posts/{id}", relative to the
+// server URL. Models maps schema names to a value of the
+// Go type the schema describes; every JSON field of that type must be a
+// property of the schema.
+func Check(routes []string, models map[string]any) error {
+	var doc document
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return fmt.Errorf("openapi: %w", err)
+	}
+
+	var errs []error
+	for _, route := range routes {
+		method, path, _ := strings.Cut(route, " ")
+		if _, ok := doc.Paths[path][strings.ToLower(method)]; !ok {
+			errs = append(errs, fmt.Errorf("openapi: route %q is not described", route))
+		}
+	}
+	for path, item := range doc.Paths {
+		for method := range item {
+			route := strings.ToUpper(method) + " " + path
+			if slices.Contains(operations, method) && !slices.Contains(routes, route) {
+				errs = append(errs, fmt.Errorf("openapi: operation %q is not served", route))
+			}
+		}
+	}
+
+	for name, model := range models {
+		schema, ok := doc.Components.Schemas[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("openapi: schema %s is missing", name))
+			continue
+		}
+		for _, field := range jsonFields(reflect.TypeOf(model)) {
+			if _, ok := schema.Properties[field]; !ok {
+				errs = append(errs, fmt.Errorf("openapi: field %s.%s is not described", name, field))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// jsonFields lists the names encoding/json uses for the fields of a struct.
+func jsonFields(t reflect.Type) []string {
+	var names []string
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
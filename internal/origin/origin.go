@@ -0,0 +1,76 @@
+// Package origin parses the origins browsers may call the API from, as
+// listed in CORS_ORIGINS, and matches the Origin headers of requests
+// against them.
+package origin
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Pattern is an allowed origin. An origin such as https://*.example.com
+// allows every subdomain of example.com, but not example.com itself.
+type Pattern struct {
+	scheme   string
+	host     string // with wildcard set, the domain whose subdomains are allowed
+	port     string
+	wildcard bool
+}
+
+// Valid reports whether s can be used as an allowed origin.
+func Valid(s string) bool {
+	_, ok := Parse(s)
+	return ok
+}
+
+// Parse reads an allowed origin: scheme://host[:port] with nothing after
+// it, where host may start with "*." to allow subdomains.
+func Parse(s string) (Pattern, bool) {
+	var p Pattern
+	if scheme, rest, ok := strings.Cut(s, "://"); ok {
+		if h, ok := strings.CutPrefix(rest, "*."); ok {
+			s, p.wildcard = scheme+"://"+h, true
+		}
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" ||
+		u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return Pattern{}, false
+	}
+	p.scheme = u.Scheme
+	p.host = strings.ToLower(u.Hostname())
+	p.port = port(u)
+
+	// Wildcards are only allowed as the first label, and not directly
+	// below a top-level domain.
+	if strings.Contains(p.host, "*") || (p.wildcard && !strings.Contains(p.host, ".")) {
+		return Pattern{}, false
+	}
+	return p, true
+}
+
+// Matches reports whether the Origin header of a request is allowed by p.
+func (p Pattern) Matches(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme != p.scheme || port(u) != p.port {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if p.wildcard {
+		return strings.HasSuffix(host, "."+p.host)
+	}
+	return host == p.host
+}
+
+// port returns the port of u, filling in the scheme's default as browsers
+// leave it out of Origin headers.
+func port(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if u.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
@@ -0,0 +1,72 @@
+package origin
+
+import "testing"
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://example.com", true},
+		{"http://localhost:3000", true},
+		{"https://*.example.com", true},
+		{"https://*.example.com:8443", true},
+		{"example.com", false},
+		{"ftp://example.com", false},
+		{"https://", false},
+		{"https://example.com/", false},
+		{"https://example.com/app", false},
+		{"https://example.com?x=1", false},
+		{"https://example.com#x", false},
+		{"https://user@example.com", false},
+		{"https://*.com", false},
+		{"https://*", false},
+		{"https://a.*.example.com", false},
+		{"https://*example.com", false},
+		{"*", false},
+	}
+	for _, tt := range tests {
+		if got := Valid(tt.origin); got != tt.want {
+			t.Errorf("Valid(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		pattern, origin string
+		want            bool
+	}{
+		{"https://example.com", "https://example.com", true},
+		{"https://example.com", "https://EXAMPLE.com", true},
+		{"https://example.com", "https://example.com:443", true},
+		{"https://example.com:443", "https://example.com", true},
+		{"http://example.com", "http://example.com:80", true},
+		{"https://example.com", "http://example.com", false},
+		{"https://example.com", "https://example.com:8443", false},
+		{"https://example.com", "https://www.example.com", false},
+		{"https://example.com", "null", false},
+		{"http://localhost:3000", "http://localhost:3000", true},
+		{"http://localhost:3000", "http://localhost:3001", false},
+
+		{"https://*.example.com", "https://app.example.com", true},
+		{"https://*.example.com", "https://a.b.example.com", true},
+		{"https://*.example.com", "https://APP.Example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://evilexample.com", false},
+		{"https://*.example.com", "https://app.example.com.evil.org", false},
+		{"https://*.example.com", "http://app.example.com", false},
+		{"https://*.example.com", "https://app.example.com:8443", false},
+		{"https://*.example.com:8443", "https://app.example.com:8443", true},
+		{"https://*.example.com:8443", "https://app.example.com", false},
+	}
+	for _, tt := range tests {
+		p, ok := Parse(tt.pattern)
+		if !ok {
+			t.Fatalf("Parse(%q) failed", tt.pattern)
+		}
+		if got := p.Matches(tt.origin); got != tt.want {
+			t.Errorf("Parse(%q).Matches(%q) = %v, want %v", tt.pattern, tt.origin, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Package problem writes API errors as RFC 7807 application/problem+json
+// documents with a stable, machine-readable code.
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"backend/internal/logging"
+	"backend/internal/requestid"
+)
+
+// Error is an error that knows how it should be presented to clients. Err
+// holds the underlying cause, which is logged but never sent.
+type Error struct {
+	Status int
+	Code   string
+	Detail string
+	Fields []Field
+	Err    error
+}
+
+// Field describes one invalid field of a request payload.
+type Field struct {
+	Name    string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Code + ": " + e.Err.Error()
+	}
+	return e.Code + ": " + e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func New(status int, code, detail string) *Error {
+	return &Error{Status: status, Code: code, Detail: detail}
+}
+
+// Validation reports every invalid field of a request at once.
+func Validation(fields ...Field) *Error {
+	return &Error{
+		Status: http.StatusBadRequest,
+		Code:   "validation_failed",
+		Detail: "The request is invalid.",
+		Fields: fields,
+	}
+}
+
+// Invalid reports a single invalid field.
+func Invalid(field, message string) *Error {
+	return Validation(Field{Name: field, Message: message})
+}
+
+// Internal hides err behind a generic message.
+func Internal(err error) *Error {
+	return &Error{
+		Status: http.StatusInternalServerError,
+		Code:   "internal_error",
+		Detail: "Something went wrong on our side.",
+		Err:    err,
+	}
+}
+
+type document struct {
+	Type      string  `json:"type"`
+	Title     string  `json:"title"`
+	Status    int     `json:"status"`
+	Detail    string  `json:"detail,omitempty"`
+	Instance  string  `json:"instance,omitempty"`
+	Code      string  `json:"code"`
+	RequestID string  `json:"request_id,omitempty"`
+	Errors    []Field `json:"errors,omitempty"`
+}
+
+// Write sends err as a problem document. Errors that are not an *Error are
+// treated as internal errors.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = Internal(err)
+	}
+
+	if e.Status >= http.StatusInternalServerError {
+		logging.FromContext(r.Context()).Error("Request failed", "code", e.Code, "err", e.Err)
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(document{
+		Type:      "about:blank",
+		Title:     http.StatusText(e.Status),
+		Status:    e.Status,
+		Detail:    e.Detail,
+		Instance:  r.URL.Path,
+		Code:      e.Code,
+		RequestID: requestid.FromContext(r.Context()),
+		Errors:    e.Fields,
+	})
+}
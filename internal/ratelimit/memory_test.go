@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	// One token per second, up to three.
+	p := Policy{Name: "test", Burst: 3, Period: 3 * time.Second}
+
+	type step struct {
+		wait time.Duration // before taking a token
+		want Result
+	}
+	allowed := func(remaining int, reset time.Duration) step {
+		s := step{want: Result{Allowed: true, Remaining: remaining, Reset: reset}}
+		if remaining == 0 {
+			s.want.RetryAfter = time.Second
+		}
+		return s
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"burst", []step{
+			allowed(2, time.Second),
+			allowed(1, 2*time.Second),
+			allowed(0, 3*time.Second),
+			{want: Result{Reset: 3 * time.Second, RetryAfter: time.Second}},
+		}},
+		{"refill", []step{
+			allowed(2, time.Second),
+			allowed(1, 2*time.Second),
+			allowed(0, 3*time.Second),
+			{wait: time.Second, want: Result{Allowed: true, Reset: 3 * time.Second, RetryAfter: time.Second}},
+		}},
+		{"partial refill", []step{
+			allowed(2, time.Second),
+			allowed(1, 2*time.Second),
+			allowed(0, 3*time.Second),
+			{wait: 500 * time.Millisecond, want: Result{Reset: 2500 * time.Millisecond, RetryAfter: 500 * time.Millisecond}},
+			{wait: 500 * time.Millisecond, want: Result{Allowed: true, Reset: 3 * time.Second, RetryAfter: time.Second}},
+		}},
+		{"refill is capped at burst", []step{
+			allowed(2, time.Second),
+			{wait: time.Hour, want: Result{Allowed: true, Remaining: 2, Reset: time.Second}},
+		}},
+		{"denied requests take nothing", []step{
+			allowed(2, time.Second),
+			allowed(1, 2*time.Second),
+			allowed(0, 3*time.Second),
+			{want: Result{Reset: 3 * time.Second, RetryAfter: time.Second}},
+			{want: Result{Reset: 3 * time.Second, RetryAfter: time.Second}},
+			{wait: time.Second, want: Result{Allowed: true, Reset: 3 * time.Second, RetryAfter: time.Second}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				m := NewMemory()
+				for i, s := range tt.steps {
+					time.Sleep(s.wait)
+					got, err := m.Take(context.Background(), "user", p)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got != s.want {
+						t.Errorf("step %d: Take = %+v, want %+v", i, got, s.want)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestMemoryKeys(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ctx := context.Background()
+		m := NewMemory()
+		login := Policy{Name: "login", Burst: 1, Period: time.Minute}
+		write := Policy{Name: "write", Burst: 1, Period: time.Minute}
+
+		for _, take := range []struct {
+			key  string
+			p    Policy
+			want bool
+		}{
+			{"a", login, true},
+			{"a", login, false},
+			{"b", login, true},
+			{"a", write, true},
+		} {
+			res, err := m.Take(ctx, take.key, take.p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Allowed != take.want {
+				t.Errorf("Take(%q, %s).Allowed = %v, want %v", take.key, take.p.Name, res.Allowed, take.want)
+			}
+		}
+
+		// Buckets that have filled up again are forgotten.
+		time.Sleep(sweepInterval)
+		if _, err := m.Take(ctx, "c", login); err != nil {
+			t.Fatal(err)
+		}
+		if len(m.buckets) != 1 {
+			t.Errorf("%d buckets kept after a sweep, want 1", len(m.buckets))
+		}
+	})
+}
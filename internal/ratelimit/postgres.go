@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Postgres keeps buckets in the rate_limits table, so every replica of the
+// API draws from the same ones. A bucket is refilled and taken from in a
+// single statement, which is safe against concurrent requests.
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+func (s *Postgres) Take(ctx context.Context, key string, p Policy) (Result, error) {
+	var (
+		tokens  float64
+		allowed bool
+	)
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO rate_limits AS b (key, tokens, allowed, updated_at, full_at)
+		VALUES ($1, $2::float8 - 1, TRUE, now(), now() + make_interval(secs => 1 / $3::float8))
+		ON CONFLICT (key) DO UPDATE SET (tokens, allowed, updated_at, full_at) = (
+			SELECT t, refilled >= 1, now(), now() + make_interval(secs => ($2::float8 - t) / $3::float8)
+			FROM (
+				SELECT refilled, CASE WHEN refilled >= 1 THEN refilled - 1 ELSE refilled END AS t
+				FROM (
+					SELECT LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * $3::float8) AS refilled
+				) r
+			) s
+		)
+		RETURNING tokens, allowed`,
+		p.Name+":"+key,
+		p.Burst,
+		p.rate(),
+	).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+	return result(p, tokens, allowed), nil
+}
+
+// Prune deletes buckets that have filled up again, which behave the same
+// as missing ones.
+func (s *Postgres) Prune(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM rate_limits WHERE full_at <= now()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
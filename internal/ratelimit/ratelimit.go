@@ -0,0 +1,82 @@
+// Package ratelimit implements token bucket rate limits. Every key, such
+// as a user or client IP, gets a bucket per policy that holds up to Burst
+// tokens and refills at Burst tokens per Period; each request takes one.
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Policy is one limit. Routes that share a policy share its buckets, so
+// for example the legacy and v1 login routes draw from the same quota.
+type Policy struct {
+	Name   string
+	Burst  int
+	Period time.Duration
+}
+
+// rate is how many tokens a bucket regains per second.
+func (p Policy) rate() float64 {
+	return float64(p.Burst) / p.Period.Seconds()
+}
+
+// String describes the policy as a RateLimit-Policy header value.
+func (p Policy) String() string {
+	return fmt.Sprintf("%d;w=%d", p.Burst, int(p.Period.Seconds()))
+}
+
+// Result is the state of a bucket after a request tried to take a token.
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// Reset is how long the bucket takes to fill up again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request would be allowed. It
+	// is zero when Remaining is not.
+	RetryAfter time.Duration
+}
+
+// Store keeps buckets. Memory keeps them per process; Postgres shares
+// them between every replica of the API.
+type Store interface {
+	Take(ctx context.Context, key string, p Policy) (Result, error)
+}
+
+// Open returns the store named by backend: "memory" or "postgres".
+func Open(backend string, conn *sql.DB) (Store, error) {
+	switch backend {
+	case "memory":
+		return NewMemory(), nil
+	case "postgres":
+		return NewPostgres(conn), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit backend %q", backend)
+	}
+}
+
+// result describes a bucket left with tokens tokens.
+func result(p Policy, tokens float64, allowed bool) Result {
+	res := Result{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((float64(p.Burst) - tokens) / p.rate()),
+	}
+	if tokens < 1 {
+		res.RetryAfter = seconds((1 - tokens) / p.rate())
+	}
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Seconds rounds d up to whole seconds, as the rate limit headers want.
+func Seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
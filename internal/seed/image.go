@@ -0,0 +1,34 @@
+package seed
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+)
+
+const imageSize = 256
+
+// image draws a topic icon: concentric rings in two random colours.
+func (g *generator) image() []byte {
+	a := color.RGBA{uint8(g.rng.IntN(256)), uint8(g.rng.IntN(256)), uint8(g.rng.IntN(256)), 255}
+	b := color.RGBA{255 - a.R, 255 - a.G, 255 - a.B, 255}
+	ring := 8 + g.rng.IntN(32)
+	cx, cy := g.rng.IntN(imageSize), g.rng.IntN(imageSize)
+
+	img := image.NewRGBA(image.Rect(0, 0, imageSize, imageSize))
+	for y := range imageSize {
+		for x := range imageSize {
+			if int(math.Hypot(float64(x-cx), float64(y-cy)))/ring%2 == 0 {
+				img.SetRGBA(x, y, a)
+			} else {
+				img.SetRGBA(x, y, b)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
@@ -0,0 +1,136 @@
+package seed
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"backend/internal/blob"
+	"backend/internal/markdown"
+	"backend/internal/store"
+
+	"github.com/lib/pq"
+)
+
+// ErrNotEmpty is returned by Insert when the database already has users
+// or topics, whose IDs and names the generated data could clash with.
+var ErrNotEmpty = errors.New("the database already holds forum data; seed an empty one")
+
+// Insert writes d into an empty database in one transaction, keeping its
+// IDs, and stores the topic images in blobs.
+func Insert(ctx context.Context, db *sql.DB, blobs blob.Store, d *Data) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if used, err := store.HasForumData(ctx, tx); err != nil {
+		return err
+	} else if used {
+		return ErrNotEmpty
+	}
+
+	err = store.InsertBatches(ctx, tx, `INSERT INTO users (id, username) SELECT * FROM unnest($1::int[], $2::text[])`,
+		len(d.Users), func(from, to int) []any {
+			var ids []int64
+			var names []string
+			for _, u := range d.Users[from:to] {
+				ids = append(ids, int64(u.ID))
+				names = append(names, u.Username)
+			}
+			return []any{pq.Array(ids), pq.Array(names)}
+		})
+	if err != nil {
+		return err
+	}
+
+	for _, t := range d.Topics {
+		key := blob.Key("topics/"+t.Name, t.Image)
+		if err := blobs.Put(ctx, key, t.Image); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO topics (name, description, image_key) VALUES ($1, $2, $3)`,
+			t.Name,
+			t.Description,
+			key,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = store.InsertBatches(ctx, tx,
+		`INSERT INTO posts (id, title, body, topic, creator, created_at, body_html)
+		SELECT * FROM unnest($1::int[], $2::text[], $3::text[], $4::text[], $5::int[], $6::timestamptz[], $7::text[])`,
+		len(d.Posts), func(from, to int) []any {
+			var (
+				ids, creators          []int64
+				titles, bodies, topics []string
+				times, html            []string
+			)
+			for _, p := range d.Posts[from:to] {
+				ids = append(ids, int64(p.ID))
+				titles = append(titles, p.Title)
+				bodies = append(bodies, p.Body)
+				html = append(html, markdown.Render(p.Body))
+				topics = append(topics, p.Topic)
+				creators = append(creators, int64(p.Creator))
+				times = append(times, p.CreatedAt.Format(time.RFC3339))
+			}
+			return []any{pq.Array(ids), pq.Array(titles), pq.Array(bodies), pq.Array(topics), pq.Array(creators), pq.Array(times), pq.Array(html)}
+		})
+	if err != nil {
+		return err
+	}
+
+	err = store.InsertBatches(ctx, tx,
+		`INSERT INTO comments (id, body, post, creator, created_at, parent, body_html)
+		SELECT id, body, post, creator, created_at, NULLIF(parent, 0), body_html
+		FROM unnest($1::int[], $2::text[], $3::int[], $4::int[], $5::timestamptz[], $6::int[], $7::text[])
+			AS c(id, body, post, creator, created_at, parent, body_html)`,
+		len(d.Comments), func(from, to int) []any {
+			var (
+				ids, posts, creators, parents []int64
+				bodies, times, html           []string
+			)
+			for _, c := range d.Comments[from:to] {
+				ids = append(ids, int64(c.ID))
+				bodies = append(bodies, c.Body)
+				html = append(html, markdown.Render(c.Body))
+				posts = append(posts, int64(c.Post))
+				creators = append(creators, int64(c.Creator))
+				times = append(times, c.CreatedAt.Format(time.RFC3339))
+				parents = append(parents, int64(c.Parent))
+			}
+			return []any{pq.Array(ids), pq.Array(bodies), pq.Array(posts), pq.Array(creators), pq.Array(times), pq.Array(parents), pq.Array(html)}
+		})
+	if err != nil {
+		return err
+	}
+
+	err = store.InsertBatches(ctx, tx,
+		`INSERT INTO post_votes (post_id, user_id, is_positive) SELECT * FROM unnest($1::int[], $2::int[], $3::bool[])`,
+		len(d.Votes), func(from, to int) []any {
+			var (
+				posts, users []int64
+				positive     []bool
+			)
+			for _, v := range d.Votes[from:to] {
+				posts = append(posts, int64(v.Post))
+				users = append(users, int64(v.User))
+				positive = append(positive, v.IsPositive)
+			}
+			return []any{pq.Array(posts), pq.Array(users), pq.Array(positive)}
+		})
+	if err != nil {
+		return err
+	}
+
+	if err := store.ResetSequences(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
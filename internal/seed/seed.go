@@ -0,0 +1,293 @@
+// Package seed generates forum data for development and load tests: users,
+// topics with images, posts, nested comments and votes. Activity follows
+// power laws, as on real forums, where a few users write most posts and a
+// few posts draw most comments and votes. The same options always produce
+// the same data.
+package seed
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options size the generated data.
+type Options struct {
+	Seed     uint64
+	Users    int
+	Topics   int
+	Posts    int
+	Comments int
+	// Votes is an upper bound, as every user votes at most once per post.
+	Votes int
+	// Start and End bound the creation times of posts and comments.
+	Start, End time.Time
+}
+
+type User struct {
+	ID       int
+	Username string
+}
+
+type Topic struct {
+	Name        string
+	Description string
+	Image       []byte // PNG
+}
+
+type Post struct {
+	ID        int
+	Title     string
+	Body      string
+	Topic     string
+	Creator   int
+	CreatedAt time.Time
+}
+
+type Comment struct {
+	ID        int
+	Body      string
+	Post      int
+	Creator   int
+	CreatedAt time.Time
+	Parent    int // 0 for top-level comments
+}
+
+type Vote struct {
+	Post       int
+	User       int
+	IsPositive bool
+}
+
+// Data is a generated forum. IDs start at 1, and comments come after
+// their parents.
+type Data struct {
+	Users    []User
+	Topics   []Topic
+	Posts    []Post
+	Comments []Comment
+	Votes    []Vote
+}
+
+// Shares of comments that reply to another comment, and of votes that are
+// upvotes.
+const (
+	replyShare  = 0.6
+	upvoteShare = 0.8
+)
+
+// The longest post body and comment the API accepts, in characters. The
+// vocabulary is ASCII, so bytes and characters are the same here.
+const (
+	maxBodyLength    = 3000
+	maxCommentLength = 500
+)
+
+// Generate builds the data described by opts.
+func Generate(opts Options) *Data {
+	g := &generator{rng: rand.New(rand.NewPCG(opts.Seed, opts.Seed^0x9e3779b97f4a7c15))}
+	d := &Data{}
+	if opts.Users < 1 || opts.Topics < 1 {
+		return d
+	}
+
+	d.Users = g.users(opts.Users)
+	d.Topics = g.topics(opts.Topics)
+
+	// Power law popularity, over a shuffled order so the most active user
+	// is not always the first one.
+	author := g.popularity(len(d.Users), 1.2)
+	topic := g.popularity(len(d.Topics), 1.1)
+
+	window := opts.End.Sub(opts.Start)
+	d.Posts = make([]Post, opts.Posts)
+	for i := range d.Posts {
+		d.Posts[i] = Post{
+			ID:        i + 1,
+			Title:     g.title(),
+			Body:      g.body(),
+			Topic:     d.Topics[topic()].Name,
+			Creator:   d.Users[author()].ID,
+			CreatedAt: opts.Start.Add(time.Duration(g.rng.Int64N(int64(window)) + 1)).Truncate(time.Second),
+		}
+	}
+	slices.SortFunc(d.Posts, func(a, b Post) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	for i := range d.Posts {
+		d.Posts[i].ID = i + 1
+	}
+	if len(d.Posts) == 0 {
+		return d
+	}
+
+	discussed := g.popularity(len(d.Posts), 1.05)
+	byPost := map[int][]int{} // indexes into d.Comments
+	d.Comments = make([]Comment, 0, opts.Comments)
+	for i := range opts.Comments {
+		p := d.Posts[discussed()]
+		c := Comment{ID: i + 1, Post: p.ID, Creator: d.Users[author()].ID}
+
+		after := p.CreatedAt
+		siblings := byPost[p.ID]
+		if len(siblings) > 0 && g.rng.Float64() < replyShare {
+			// Recent comments are the likeliest to be answered.
+			parent := d.Comments[siblings[len(siblings)-1-g.rng.IntN(min(len(siblings), 8))]]
+			c.Parent = parent.ID
+			after = parent.CreatedAt
+			c.Body = g.comment("@" + d.Users[parent.Creator-1].Username + " ")
+		} else {
+			c.Body = g.comment("")
+		}
+		c.CreatedAt = g.timeAfter(after, opts.End)
+
+		byPost[p.ID] = append(siblings, len(d.Comments))
+		d.Comments = append(d.Comments, c)
+	}
+
+	// Popular posts run out of users who have not voted on them yet, so
+	// draws are retried, up to a limit for when Votes is close to the
+	// number of user and post pairs.
+	voted := g.popularity(len(d.Posts), 1.05)
+	seen := map[[2]int]bool{}
+	for attempts := 0; len(d.Votes) < opts.Votes && attempts < 4*opts.Votes; attempts++ {
+		v := Vote{Post: d.Posts[voted()].ID, User: d.Users[g.rng.IntN(len(d.Users))].ID}
+		if seen[[2]int{v.Post, v.User}] {
+			continue
+		}
+		seen[[2]int{v.Post, v.User}] = true
+		v.IsPositive = g.rng.Float64() < upvoteShare
+		d.Votes = append(d.Votes, v)
+	}
+
+	return d
+}
+
+type generator struct {
+	rng *rand.Rand
+}
+
+// popularity returns a picker of indexes below n, where the kth most
+// popular index is picked about 1/k^s as often as the most popular one.
+func (g *generator) popularity(n int, s float64) func() int {
+	order := g.rng.Perm(n)
+	if n == 1 {
+		return func() int { return 0 }
+	}
+	zipf := rand.NewZipf(g.rng, s, 1, uint64(n-1))
+	return func() int { return order[zipf.Uint64()] }
+}
+
+// timeAfter picks a time between t and end, most likely within hours of t.
+func (g *generator) timeAfter(t, end time.Time) time.Time {
+	at := t.Add(time.Minute + time.Duration(g.rng.ExpFloat64()*float64(6*time.Hour)))
+	if at.After(end) {
+		at = t.Add(time.Duration(g.rng.Int64N(int64(end.Sub(t)) + 1)))
+	}
+	return at.Truncate(time.Second)
+}
+
+func (g *generator) pick(words []string) string {
+	return words[g.rng.IntN(len(words))]
+}
+
+func (g *generator) users(n int) []User {
+	users := make([]User, n)
+	taken := map[string]bool{}
+	for i := range users {
+		name := g.pick(firstNames) + g.pick(nameSuffixes)
+		if taken[name] || g.rng.IntN(3) == 0 {
+			name += strconv.Itoa(g.rng.IntN(1000))
+		}
+		for taken[name] {
+			name += strconv.Itoa(g.rng.IntN(10))
+		}
+		taken[name] = true
+		users[i] = User{ID: i + 1, Username: name}
+	}
+	return users
+}
+
+func (g *generator) topics(n int) []Topic {
+	topics := make([]Topic, n)
+	for i := range topics {
+		subject := topicSubjects[i%len(topicSubjects)]
+		name := subject
+		if i >= len(topicSubjects) {
+			name += strconv.Itoa(i/len(topicSubjects) + 1)
+		}
+		topics[i] = Topic{
+			Name:        name,
+			Description: "Everything about " + strings.ToLower(subject) + ". " + g.sentence(),
+			Image:       g.image(),
+		}
+	}
+	return topics
+}
+
+// title writes a few words, under the 100 characters a title may have.
+func (g *generator) title() string {
+	t := g.phrase(3, 7)
+	if g.rng.IntN(3) == 0 {
+		t += "?"
+	}
+	return t
+}
+
+// body writes a few paragraphs of markdown, leaving out those that would
+// take it over maxBodyLength.
+func (g *generator) body() string {
+	var body string
+	for i := range 1 + g.rng.IntN(4) {
+		var paragraph string
+		switch g.rng.IntN(6) {
+		case 0:
+			var items []string
+			for range 2 + g.rng.IntN(3) {
+				items = append(items, "- "+g.phrase(2, 6))
+			}
+			paragraph = strings.Join(items, "\n")
+		case 1:
+			paragraph = g.sentence() + " **" + g.phrase(2, 4) + "** " + g.sentence()
+		default:
+			var sentences []string
+			for range 2 + g.rng.IntN(4) {
+				sentences = append(sentences, g.sentence())
+			}
+			paragraph = strings.Join(sentences, " ")
+		}
+		if i == 0 {
+			body = paragraph
+		} else if len(body)+2+len(paragraph) <= maxBodyLength {
+			body += "\n\n" + paragraph
+		}
+	}
+	return body
+}
+
+// comment writes one to three sentences after prefix, leaving out those
+// that would take it over maxCommentLength.
+func (g *generator) comment(prefix string) string {
+	c := prefix + g.sentence()
+	for range g.rng.IntN(3) {
+		if s := g.sentence(); len(c)+1+len(s) <= maxCommentLength {
+			c += " " + s
+		}
+	}
+	return c
+}
+
+func (g *generator) sentence() string {
+	return g.phrase(5, 14) + g.pick([]string{".", ".", ".", "!", "?"})
+}
+
+// phrase strings together between min and max words, capitalising the
+// first.
+func (g *generator) phrase(min, max int) string {
+	words := make([]string, min+g.rng.IntN(max-min+1))
+	for i := range words {
+		words[i] = g.pick(vocabulary)
+	}
+	words[0] = strings.ToUpper(words[0][:1]) + words[0][1:]
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,111 @@
+package seed
+
+import (
+	"math/rand/v2"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/handlers"
+)
+
+func testOptions(seed uint64) Options {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return Options{
+		Seed:     seed,
+		Users:    200,
+		Topics:   20,
+		Posts:    1000,
+		Comments: 5000,
+		Votes:    5000,
+		Start:    start,
+		End:      start.AddDate(1, 0, 0),
+	}
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	a, b := Generate(testOptions(1)), Generate(testOptions(1))
+	if !reflect.DeepEqual(a, b) {
+		t.Error("two runs with the same options generated different data")
+	}
+	if c := Generate(testOptions(2)); reflect.DeepEqual(a, c) {
+		t.Error("different seeds generated the same data")
+	}
+}
+
+// Generated data must be data the API would accept, unchanged.
+func TestGenerateIsValid(t *testing.T) {
+	opts := testOptions(3)
+	d := Generate(opts)
+
+	check := func(kind string, err error, changed bool) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("invalid %s: %v", kind, err)
+		}
+		if changed {
+			t.Fatalf("validating a %s changed it", kind)
+		}
+	}
+	for _, u := range d.Users {
+		req := handlers.LoginRequest{Username: u.Username}
+		check("username "+u.Username, req.Validate(), req.Username != u.Username)
+	}
+	for _, topic := range d.Topics {
+		name, description, err := handlers.ValidateTopic(topic.Name, topic.Description)
+		check("topic "+topic.Name, err, name != topic.Name || description != topic.Description)
+	}
+	for _, p := range d.Posts {
+		req := handlers.CreatePostRequest{Title: p.Title, Body: p.Body, Topic: p.Topic}
+		check("post", req.Validate(), req.Title != p.Title || req.Body != p.Body)
+		if p.CreatedAt.Before(opts.Start) || p.CreatedAt.After(opts.End) {
+			t.Fatalf("post %d created at %v, outside the window", p.ID, p.CreatedAt)
+		}
+	}
+
+	byID := map[int]Comment{}
+	for _, c := range d.Comments {
+		req := handlers.CommentRequest{Body: c.Body}
+		check("comment", req.Validate(), req.Body != c.Body)
+		if c.Parent != 0 {
+			parent, ok := byID[c.Parent]
+			if !ok || parent.Post != c.Post || c.CreatedAt.Before(parent.CreatedAt) {
+				t.Fatalf("comment %d does not follow its parent %d on post %d", c.ID, c.Parent, c.Post)
+			}
+		}
+		byID[c.ID] = c
+	}
+
+	seen := map[[2]int]bool{}
+	for _, v := range d.Votes {
+		if seen[[2]int{v.Post, v.User}] {
+			t.Fatalf("user %d voted twice on post %d", v.User, v.Post)
+		}
+		seen[[2]int{v.Post, v.User}] = true
+	}
+}
+
+// Bodies and comments stay within their limits however many and however
+// long the sentences drawn for them are.
+func TestLengthLimits(t *testing.T) {
+	g := &generator{rng: rand.New(rand.NewPCG(4, 5))}
+	mention := "@" + strings.Repeat("x", 20) + " "
+	longest := map[string]int{}
+	for range 20000 {
+		if n := len(g.comment(mention)); n > longest["comment"] {
+			longest["comment"] = n
+		}
+		if n := len(g.body()); n > longest["body"] {
+			longest["body"] = n
+		}
+		if n := len(g.title()); n > longest["title"] {
+			longest["title"] = n
+		}
+	}
+	for kind, limit := range map[string]int{"comment": maxCommentLength, "body": maxBodyLength, "title": 100} {
+		if longest[kind] > limit {
+			t.Errorf("longest %s has %d characters, over the limit of %d", kind, longest[kind], limit)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package seed
+
+var firstNames = []string{
+	"ada", "alex", "amir", "ana", "ben", "bo", "cam", "chen", "dana", "dev",
+	"eli", "emma", "finn", "gia", "hana", "ian", "ivy", "jay", "jo", "kai",
+	"kim", "lea", "leo", "liam", "lin", "max", "mia", "nia", "noa", "oli",
+	"omar", "pia", "raj", "ren", "rosa", "sam", "sara", "tao", "uma", "vic",
+	"wen", "yara", "yuki", "zoe",
+}
+
+var nameSuffixes = []string{
+	"", "", "", "bytes", "cat", "dev", "fox", "jr", "kid", "owl", "rs", "theo", "writes", "x", "zen",
+}
+
+// topicSubjects name the generated topics. Names must be alphanumeric.
+var topicSubjects = []string{
+	"Programming", "Cooking", "Gardening", "Music", "Books", "Films", "Travel",
+	"Photography", "Gaming", "Fitness", "Science", "History", "Design", "Pets",
+	"Cycling", "Languages",
+}
+
+// vocabulary is what titles, posts and comments are made of. No word is
+// longer than 12 characters, which keeps titles within their limit.
+var vocabulary = []string{
+	"about", "actually", "after", "again", "almost", "always", "another", "anyone",
+	"around", "because", "before", "best", "better", "between", "build", "change",
+	"cheap", "choose", "clean", "clear", "common", "could", "course", "daily",
+	"decide", "different", "easy", "enough", "every", "example", "experience", "explain",
+	"fast", "favourite", "feel", "final", "first", "found", "friend", "garden",
+	"guide", "happy", "hard", "help", "honestly", "idea", "important", "interesting",
+	"issue", "keep", "kind", "learn", "little", "local", "long", "maybe",
+	"method", "mistake", "month", "morning", "never", "night", "notice", "often",
+	"old", "others", "people", "perfect", "personal", "place", "plan", "point",
+	"pretty", "problem", "project", "question", "quick", "quite", "really", "reason",
+	"recipe", "recommend", "remember", "result", "right", "simple", "small", "someone",
+	"something", "start", "still", "story", "strange", "strong", "suggest", "sure",
+	"system", "thanks", "thing", "think", "together", "tool", "tried", "trouble",
+	"usually", "weekend", "while", "whole", "without", "wonder", "work", "world",
+	"worth", "write", "wrong", "year", "young",
+}
@@ -0,0 +1,171 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"backend/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// DeletedUsername names the account that the posts, comments and images of
+// deleted users are handed to, so threads stay intact without saying who
+// wrote what. It fails username validation, so nobody can log in as it.
+const DeletedUsername = "[deleted user]"
+
+// ErrDeletedUser is returned by DeleteAccount for the DeletedUsername
+// account, whose deletion would take every anonymised post with it.
+var ErrDeletedUser = errors.New("the account of deleted users cannot be deleted")
+
+// AccountDeletion returns when a user asked for their account to be
+// deleted and when it will be, or ErrNotFound if they have not.
+func AccountDeletion(ctx context.Context, q Querier, userID int, grace time.Duration) (models.AccountDeletion, error) {
+	var d models.AccountDeletion
+	err := q.QueryRowContext(ctx,
+		named("AccountDeletion", `SELECT deletion_requested_at, deletion_requested_at + make_interval(secs => $2)
+		FROM users WHERE id = $1 AND deletion_requested_at IS NOT NULL`),
+		userID,
+		grace.Seconds(),
+	).Scan(&d.RequestedAt, &d.DeletesAt)
+	return d, notFound(err)
+}
+
+// RequestAccountDeletion schedules a user's account to be deleted once
+// grace has passed. Asking again keeps the original schedule.
+func RequestAccountDeletion(ctx context.Context, q Querier, userID int, grace time.Duration) (models.AccountDeletion, error) {
+	var d models.AccountDeletion
+	err := q.QueryRowContext(ctx,
+		`UPDATE users SET deletion_requested_at = COALESCE(deletion_requested_at, now()) WHERE id = $1
+		RETURNING deletion_requested_at, deletion_requested_at + make_interval(secs => $2)`,
+		userID,
+		grace.Seconds(),
+	).Scan(&d.RequestedAt, &d.DeletesAt)
+	return d, notFound(err)
+}
+
+// CancelAccountDeletion keeps a user's account, or returns ErrNotFound if
+// no deletion was pending.
+func CancelAccountDeletion(ctx context.Context, q Querier, userID int) error {
+	res, err := q.ExecContext(ctx,
+		`UPDATE users SET deletion_requested_at = NULL WHERE id = $1 AND deletion_requested_at IS NOT NULL`,
+		userID,
+	)
+	return affected(res, err)
+}
+
+// AccountsDueForDeletion lists the users whose deletion was requested more
+// than grace ago.
+func AccountsDueForDeletion(ctx context.Context, q Querier, grace time.Duration) ([]int, error) {
+	rows, err := q.QueryContext(ctx,
+		named("AccountsDueForDeletion", `SELECT id FROM users
+		WHERE deletion_requested_at < now() - make_interval(secs => $1) ORDER BY id`),
+		grace.Seconds(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// DeleteAccount removes a user with their profile, image, votes, roles,
+// unattached uploads and stored idempotent responses. Their posts, comments
+// and attached images move to the DeletedUsername account. q should be a
+// transaction, after whose commit the returned blob keys are no longer
+// used and can be discarded.
+func DeleteAccount(ctx context.Context, q Querier, userID int) ([]sql.NullString, error) {
+	// Locking the user holds off writes that would reference them until
+	// the account is gone, after which they fail.
+	var username string
+	err := q.QueryRowContext(ctx, `SELECT username FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&username)
+	if err != nil {
+		return nil, notFound(err)
+	} else if username == DeletedUsername {
+		return nil, ErrDeletedUser
+	}
+
+	var deletedID int
+	err = q.QueryRowContext(ctx,
+		`INSERT INTO users (username) VALUES ($1)
+		ON CONFLICT (username) DO UPDATE SET username = EXCLUDED.username
+		RETURNING id`,
+		DeletedUsername,
+	).Scan(&deletedID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Versions are bumped because the creator is part of what clients
+	// cached.
+	for _, query := range []string{
+		`UPDATE posts SET creator = $2, version = version + 1 WHERE creator = $1`,
+		`UPDATE comments SET creator = $2, version = version + 1 WHERE creator = $1`,
+		`UPDATE media SET uploader = $2 WHERE uploader = $1 AND post IS NOT NULL`,
+	} {
+		if _, err := q.ExecContext(ctx, query, userID, deletedID); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := q.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+
+	var uploads []string
+	rows, err := q.QueryContext(ctx, `DELETE FROM media WHERE uploader = $1 RETURNING blob_key`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key sql.NullString
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		if key.Valid {
+			uploads = append(uploads, key.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Votes and roles go with the user row.
+	var image sql.NullString
+	err = q.QueryRowContext(ctx, `DELETE FROM users WHERE id = $1 RETURNING image_key`, userID).Scan(&image)
+	if err != nil {
+		return nil, err
+	}
+	keys := []sql.NullString{image}
+
+	// Uploads are content-addressed per uploader, so a deleted upload may
+	// share its blob with an attached one that now belongs to
+	// DeletedUsername.
+	rows, err = q.QueryContext(ctx,
+		`SELECT DISTINCT k FROM unnest($1::text[]) AS k WHERE NOT EXISTS (SELECT 1 FROM media WHERE blob_key = k)`,
+		pq.Array(uploads),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key sql.NullString
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
@@ -0,0 +1,39 @@
+package store
+
+import "context"
+
+// BatchSize is how many rows each InsertBatches statement writes.
+const BatchSize = 5000
+
+// HasForumData reports whether any users or topics exist, whose IDs and
+// names bulk loads with explicit keys could clash with.
+func HasForumData(ctx context.Context, q Querier) (bool, error) {
+	var used bool
+	err := q.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users) OR EXISTS (SELECT 1 FROM topics)`).Scan(&used)
+	return used, err
+}
+
+// InsertBatches runs query for rows [0, n) in batches of BatchSize, with
+// the arguments args returns for each batch.
+func InsertBatches(ctx context.Context, q Querier, query string, n int, args func(from, to int) []any) error {
+	for from := 0; from < n; from += BatchSize {
+		if _, err := q.ExecContext(ctx, query, args(from, min(from+BatchSize, n))...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResetSequences moves the ID sequences past the IDs written explicitly,
+// so rows created later do not collide with them.
+func ResetSequences(ctx context.Context, q Querier) error {
+	for _, table := range []string{"users", "posts", "comments", "media"} {
+		_, err := q.ExecContext(ctx,
+			`SELECT setval(pg_get_serial_sequence('`+table+`', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM `+table,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"backend/internal/models"
+)
+
+// CommentColumns are the columns ScanComment reads, in order. Writes use
+// them in RETURNING clauses to respond with the stored comment.
+const CommentColumns = `id, body, body_html, post, creator, created_at, is_edited, parent, version`
+
+// selectComments reads comments that have not been deleted, on posts that
+// have not been either. Callers append conditions with AND.
+const selectComments = `SELECT ` + CommentColumns + ` FROM comments
+	WHERE deleted_at IS NULL AND post IN (SELECT id FROM posts WHERE deleted_at IS NULL)`
+
+// ScanComment reads a row of CommentColumns.
+func ScanComment(row interface{ Scan(...any) error }) (models.Comment, error) {
+	var (
+		c    models.Comment
+		html sql.NullString
+	)
+	if err := row.Scan(&c.ID, &c.Body, &html, &c.Post, &c.Creator, &c.CreatedAt, &c.IsEdited, &c.Parent, &c.Version); err != nil {
+		return models.Comment{}, err
+	}
+	c.BodyHTML = bodyHTML(c.Body, html)
+	return c, nil
+}
+
+func Comment(ctx context.Context, q Querier, id int) (models.Comment, error) {
+	rows, err := q.QueryContext(ctx, named("Comment", selectComments+` AND id = $1`), id)
+	if err != nil {
+		return models.Comment{}, err
+	}
+	comments, err := scanComments(rows)
+	if err != nil {
+		return models.Comment{}, err
+	}
+	if len(comments) == 0 {
+		return models.Comment{}, ErrNotFound
+	}
+	return comments[0], nil
+}
+
+// CommentsByPost returns the comments on a post, oldest first.
+func CommentsByPost(ctx context.Context, q Querier, postID int) ([]models.Comment, error) {
+	rows, err := q.QueryContext(ctx,
+		named("CommentsByPost", selectComments+` AND post = $1 ORDER BY created_at ASC, id ASC`),
+		postID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanComments(rows)
+}
+
+func scanComments(rows *sql.Rows) ([]models.Comment, error) {
+	defer rows.Close()
+
+	comments := []models.Comment{}
+	for rows.Next() {
+		c, err := ScanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// SetCommentDeleted hides a comment from every read, or shows it again when
+// deleted is false. Replies to it stay visible.
+func SetCommentDeleted(ctx context.Context, q Querier, id int, deleted bool) error {
+	res, err := q.ExecContext(ctx,
+		`UPDATE comments SET deleted_at = CASE WHEN $2 THEN COALESCE(deleted_at, now()) END WHERE id = $1`,
+		id,
+		deleted,
+	)
+	return affected(res, err)
+}
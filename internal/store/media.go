@@ -0,0 +1,23 @@
+package store
+
+import "context"
+
+// LockBlob holds a lock on a blob key until the transaction q belongs to
+// ends. Uploads are content-addressed, so uploads of the same file share a
+// blob: uploads hold the lock while they store the blob and add the row
+// that references it, and pruning holds it while it checks that no row
+// does before deleting the blob.
+func LockBlob(ctx context.Context, q Querier, key string) error {
+	_, err := q.ExecContext(ctx, named("LockBlob", `SELECT pg_advisory_xact_lock(hashtext($1))`), key)
+	return err
+}
+
+// MediaBlobInUse reports whether any upload references the blob under key.
+func MediaBlobInUse(ctx context.Context, q Querier, key string) (bool, error) {
+	var used bool
+	err := q.QueryRowContext(ctx,
+		named("MediaBlobInUse", `SELECT EXISTS (SELECT 1 FROM media WHERE blob_key = $1)`),
+		key,
+	).Scan(&used)
+	return used, err
+}
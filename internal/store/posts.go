@@ -0,0 +1,162 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	"backend/internal/markdown"
+	"backend/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// selectPosts reads posts that have not been deleted, with their score and
+// the vote cast by the viewer in $1. Callers append conditions with AND,
+// using $2 onwards.
+const selectPosts = `
+	SELECT
+		p.id,
+		p.title,
+		p.body,
+		p.body_html,
+		p.topic,
+		p.creator,
+		p.created_at,
+		p.is_edited,
+		p.version,
+		COALESCE(
+			(SELECT SUM(CASE WHEN is_positive THEN 1 ELSE -1 END)
+			FROM post_votes
+			WHERE post_id = p.id),
+		0) AS score,
+		(SELECT CASE WHEN is_positive THEN 1 ELSE -1 END
+		FROM post_votes
+		WHERE post_id = p.id AND user_id = $1) AS user_vote
+	FROM posts p
+	WHERE p.deleted_at IS NULL`
+
+// Post returns a post as seen by viewerID, who may be 0 for anonymous
+// requests.
+func Post(ctx context.Context, q Querier, id, viewerID int) (models.Post, error) {
+	rows, err := q.QueryContext(ctx, named("Post", selectPosts+` AND p.id = $2`), viewerID, id)
+	if err != nil {
+		return models.Post{}, err
+	}
+	posts, err := scanPosts(ctx, q, rows)
+	if err != nil {
+		return models.Post{}, err
+	}
+	if len(posts) == 0 {
+		return models.Post{}, ErrNotFound
+	}
+	return posts[0], nil
+}
+
+// PostsByTopic returns the posts of a topic, highest score first.
+func PostsByTopic(ctx context.Context, q Querier, topic string, viewerID int) ([]models.Post, error) {
+	rows, err := q.QueryContext(ctx,
+		named("PostsByTopic", selectPosts+` AND p.topic = $2 ORDER BY score DESC, p.id DESC`),
+		viewerID,
+		topic,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanPosts(ctx, q, rows)
+}
+
+func scanPosts(ctx context.Context, q Querier, rows *sql.Rows) ([]models.Post, error) {
+	defer rows.Close()
+
+	posts := []models.Post{}
+	for rows.Next() {
+		var (
+			p        models.Post
+			html     sql.NullString
+			userVote sql.NullInt64
+		)
+		err := rows.Scan(&p.ID, &p.Title, &p.Body, &html, &p.Topic, &p.Creator, &p.CreatedAt, &p.IsEdited, &p.Version, &p.Score, &userVote)
+		if err != nil {
+			return nil, err
+		}
+		if userVote.Valid {
+			p.UserVote = int(userVote.Int64)
+		}
+		p.BodyHTML = bodyHTML(p.Body, html)
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := loadAttachments(ctx, q, posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// bodyHTML returns the HTML stored for the markdown body of a post or
+// comment, rendering it for rows written before it was stored.
+func bodyHTML(body string, html sql.NullString) string {
+	if html.Valid {
+		return html.String
+	}
+	return markdown.Render(body)
+}
+
+// MediaURL is where an uploaded image is served.
+func MediaURL(id int) string {
+	return APIPrefix + "/media/" + strconv.Itoa(id)
+}
+
+// loadAttachments fills in the attachments of every post in posts.
+func loadAttachments(ctx context.Context, q Querier, posts []models.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	index := make(map[int]*models.Post, len(posts))
+	ids := make([]int64, len(posts))
+	for i := range posts {
+		posts[i].Attachments = []models.Attachment{}
+		index[posts[i].ID] = &posts[i]
+		ids[i] = int64(posts[i].ID)
+	}
+
+	rows, err := q.QueryContext(ctx,
+		named("Attachments", `SELECT id, post, alt FROM media WHERE post = ANY($1) ORDER BY post, position`),
+		pq.Array(ids),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			a      models.Attachment
+			postID int
+		)
+		if err := rows.Scan(&a.ID, &postID, &a.Alt); err != nil {
+			return err
+		}
+		a.URL = MediaURL(a.ID)
+		index[postID].Attachments = append(index[postID].Attachments, a)
+	}
+
+	return rows.Err()
+}
+
+// SetPostDeleted hides a post and its comments from every read, or shows
+// them again when deleted is false. Unlike deleting a post through the
+// API, this can be undone.
+func SetPostDeleted(ctx context.Context, q Querier, id int, deleted bool) error {
+	res, err := q.ExecContext(ctx,
+		`UPDATE posts SET deleted_at = CASE WHEN $2 THEN COALESCE(deleted_at, now()) END WHERE id = $1`,
+		id,
+		deleted,
+	)
+	return affected(res, err)
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Roles grant users powers beyond managing their own content.
+const (
+	// RoleAdmin can do everything a moderator can.
+	RoleAdmin = "admin"
+	// RoleModerator removes posts and comments of other users.
+	RoleModerator = "moderator"
+)
+
+// RoleNames lists every role, as allowed by the user_roles table.
+var RoleNames = []string{RoleAdmin, RoleModerator}
+
+// Grant is a role held by a user.
+type Grant struct {
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+// HasRole reports whether a user holds any of roles.
+func HasRole(ctx context.Context, q Querier, userID int, roles ...string) (bool, error) {
+	var ok bool
+	err := q.QueryRowContext(ctx,
+		named("HasRole", `SELECT EXISTS (SELECT 1 FROM user_roles WHERE user_id = $1 AND role = ANY($2))`),
+		userID,
+		pq.Array(roles),
+	).Scan(&ok)
+	return ok, err
+}
+
+// Grants lists the roles held by users, by username.
+func Grants(ctx context.Context, q Querier) ([]Grant, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT r.user_id, u.username, r.role, r.granted_at
+		FROM user_roles r JOIN users u ON u.id = r.user_id
+		ORDER BY u.username, r.role`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := []Grant{}
+	for rows.Next() {
+		var g Grant
+		if err := rows.Scan(&g.UserID, &g.Username, &g.Role, &g.GrantedAt); err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// GrantRole gives a user a role. Granting a role the user already holds
+// does nothing.
+func GrantRole(ctx context.Context, q Querier, userID int, role string) error {
+	_, err := q.ExecContext(ctx,
+		`INSERT INTO user_roles (user_id, role) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		userID,
+		role,
+	)
+	return err
+}
+
+// RevokeRole takes a role away from a user, returning ErrNotFound if the
+// user did not hold it.
+func RevokeRole(ctx context.Context, q Querier, userID int, role string) error {
+	res, err := q.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id = $1 AND role = $2`, userID, role)
+	return affected(res, err)
+}
@@ -0,0 +1,39 @@
+package store
+
+import "context"
+
+// Stats are row counts for operators. Deleted posts and comments are
+// counted apart from visible ones.
+type Stats struct {
+	Users           int `json:"users"`
+	BannedUsers     int `json:"banned_users"`
+	Topics          int `json:"topics"`
+	Posts           int `json:"posts"`
+	DeletedPosts    int `json:"deleted_posts"`
+	Comments        int `json:"comments"`
+	DeletedComments int `json:"deleted_comments"`
+	Votes           int `json:"votes"`
+	Media           int `json:"media"`
+	PostsToday      int `json:"posts_last_24h"`
+	CommentsToday   int `json:"comments_last_24h"`
+}
+
+func CountAll(ctx context.Context, q Querier) (Stats, error) {
+	var s Stats
+	err := q.QueryRowContext(ctx, named("Stats", `
+		SELECT
+			(SELECT count(*) FROM users),
+			(SELECT count(*) FROM users WHERE banned_at IS NOT NULL),
+			(SELECT count(*) FROM topics),
+			(SELECT count(*) FROM posts WHERE deleted_at IS NULL),
+			(SELECT count(*) FROM posts WHERE deleted_at IS NOT NULL),
+			(SELECT count(*) FROM comments WHERE deleted_at IS NULL),
+			(SELECT count(*) FROM comments WHERE deleted_at IS NOT NULL),
+			(SELECT count(*) FROM post_votes),
+			(SELECT count(*) FROM media),
+			(SELECT count(*) FROM posts WHERE created_at > now() - interval '1 day'),
+			(SELECT count(*) FROM comments WHERE created_at > now() - interval '1 day')`),
+	).Scan(&s.Users, &s.BannedUsers, &s.Topics, &s.Posts, &s.DeletedPosts, &s.Comments, &s.DeletedComments,
+		&s.Votes, &s.Media, &s.PostsToday, &s.CommentsToday)
+	return s, err
+}
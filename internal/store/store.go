@@ -0,0 +1,80 @@
+// Package store holds the queries that read forum data into the shapes
+// returned by the API, so every endpoint that returns a resource returns
+// the same representation, the moderation writes that the API and
+// forumctl share, and helpers for bulk loads.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// APIPrefix is where the current version of the API is mounted. Resource
+// URLs such as ImageURL are built below it.
+const APIPrefix = "/api/v1"
+
+var (
+	ErrNotFound = errors.New("not found")
+	// ErrExists is returned by writes that would duplicate a unique name.
+	ErrExists = errors.New("already exists")
+)
+
+// Querier is satisfied by both *sql.DB and *sql.Tx, so reads can see the
+// uncommitted writes of the transaction they run in.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// ImageVersion identifies one revision of a user or topic image. It is
+// derived from image_updated_at with microsecond precision.
+func ImageVersion(imageEpoch float64) string {
+	return strconv.FormatInt(int64(math.Round(imageEpoch*1e6)), 36)
+}
+
+// versionedImageURL appends the image version to its URL. The URL changes
+// whenever the image does, so responses to it can be cached indefinitely.
+func versionedImageURL(path string, imageEpoch float64) *string {
+	url := path + "?v=" + ImageVersion(imageEpoch)
+	return &url
+}
+
+// named prefixes query with the comment the database layer names its
+// trace spans after. Postgres ignores the comment.
+func named(name, query string) string {
+	return "-- name: " + name + "\n" + query
+}
+
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// affected turns a write that matched no rows into ErrNotFound.
+func affected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func uniqueViolation(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrExists
+	}
+	return err
+}
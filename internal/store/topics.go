@@ -0,0 +1,102 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"backend/internal/models"
+)
+
+// TopicColumns are the columns ScanTopic reads, in order. Writes use them
+// in RETURNING clauses to respond with the stored topic.
+const TopicColumns = `name, description, image IS NOT NULL OR image_key IS NOT NULL, EXTRACT(EPOCH FROM image_updated_at), version`
+
+const selectTopics = `SELECT ` + TopicColumns + ` FROM topics`
+
+// ScanTopic reads a row of TopicColumns. Any columns that follow them are
+// scanned into extra.
+func ScanTopic(row interface{ Scan(...any) error }, extra ...any) (models.Topic, error) {
+	var (
+		t          models.Topic
+		hasImage   bool
+		imageEpoch float64
+	)
+	dest := append([]any{&t.Name, &t.Description, &hasImage, &imageEpoch, &t.Version}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return models.Topic{}, err
+	}
+	if hasImage {
+		t.ImageURL = versionedImageURL(APIPrefix+"/topics/"+t.Name+"/image", imageEpoch)
+		t.ImageUpdatedAt = int64(imageEpoch)
+	}
+	return t, nil
+}
+
+func Topic(ctx context.Context, q Querier, name string) (models.Topic, error) {
+	rows, err := q.QueryContext(ctx, named("Topic", selectTopics+` WHERE name = $1`), name)
+	if err != nil {
+		return models.Topic{}, err
+	}
+	topics, err := scanTopics(rows)
+	if err != nil {
+		return models.Topic{}, err
+	}
+	if len(topics) == 0 {
+		return models.Topic{}, ErrNotFound
+	}
+	return topics[0], nil
+}
+
+func Topics(ctx context.Context, q Querier) ([]models.Topic, error) {
+	rows, err := q.QueryContext(ctx, named("Topics", selectTopics+` ORDER BY name`))
+	if err != nil {
+		return nil, err
+	}
+	return scanTopics(rows)
+}
+
+func scanTopics(rows *sql.Rows) ([]models.Topic, error) {
+	defer rows.Close()
+
+	topics := []models.Topic{}
+	for rows.Next() {
+		t, err := ScanTopic(rows)
+		if err != nil {
+			return nil, err
+		}
+		topics = append(topics, t)
+	}
+	return topics, rows.Err()
+}
+
+// CreateTopic adds a topic, returning ErrExists if the name is taken.
+// imageKey is the blob key of its image, or nil.
+func CreateTopic(ctx context.Context, q Querier, name, description string, imageKey any) (models.Topic, error) {
+	t, err := ScanTopic(q.QueryRowContext(ctx,
+		`INSERT INTO topics (name, description, image_key) VALUES ($1, $2, $3)
+		RETURNING `+TopicColumns,
+		name,
+		description,
+		imageKey,
+	))
+	return t, uniqueViolation(err)
+}
+
+// RenameTopic moves a topic and its posts to a new name.
+func RenameTopic(ctx context.Context, q Querier, name, newName string) (models.Topic, error) {
+	t, err := ScanTopic(q.QueryRowContext(ctx,
+		`UPDATE topics SET name = $2, version = version + 1 WHERE name = $1
+		RETURNING `+TopicColumns,
+		name,
+		newName,
+	))
+	return t, uniqueViolation(notFound(err))
+}
+
+// DeleteTopic deletes a topic with all of its posts, and returns the key of
+// its image for the caller to discard.
+func DeleteTopic(ctx context.Context, q Querier, name string) (sql.NullString, error) {
+	var imageKey sql.NullString
+	err := q.QueryRowContext(ctx, `DELETE FROM topics WHERE name = $1 RETURNING image_key`, name).Scan(&imageKey)
+	return imageKey, notFound(err)
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"strconv"
+
+	"backend/internal/models"
+)
+
+// UserColumns are the columns ScanUser reads, in order. Writes use them in
+// RETURNING clauses to respond with the stored user.
+const UserColumns = `id, username, image IS NOT NULL OR image_key IS NOT NULL, EXTRACT(EPOCH FROM image_updated_at)`
+
+// ScanUser reads a row of UserColumns. Any columns that follow them are
+// scanned into extra.
+func ScanUser(row interface{ Scan(...any) error }, extra ...any) (models.User, error) {
+	var (
+		u          models.User
+		hasImage   bool
+		imageEpoch float64
+	)
+	dest := append([]any{&u.ID, &u.Username, &hasImage, &imageEpoch}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return models.User{}, err
+	}
+	if hasImage {
+		u.ImageURL = versionedImageURL(APIPrefix+"/users/"+u.Username+"/image", imageEpoch)
+		u.ImageUpdatedAt = int64(imageEpoch)
+	}
+	return u, nil
+}
+
+// User looks a user up by ID, or by username when ref is not a number.
+func User(ctx context.Context, q Querier, ref string) (models.User, error) {
+	where, arg := UserRef(ref)
+	u, err := ScanUser(q.QueryRowContext(ctx, named("User", `SELECT `+UserColumns+` FROM users WHERE `+where), arg))
+	return u, notFound(err)
+}
+
+// UserRef returns the condition, with $1 standing for arg, that finds the
+// user ref names: by ID when ref is a number, and by username otherwise.
+// Usernames always contain a letter, so the two cannot clash, but they may
+// start with digits.
+func UserRef(ref string) (where string, arg any) {
+	if id, err := strconv.Atoi(ref); err == nil {
+		return "id = $1", id
+	}
+	return "username = $1", ref
+}
+
+// BanStatus returns whether a user is banned and why, or ErrNotFound if
+// there is no such user.
+func BanStatus(ctx context.Context, q Querier, userID int) (banned bool, reason string, err error) {
+	err = q.QueryRowContext(ctx,
+		named("BanStatus", `SELECT banned_at IS NOT NULL, ban_reason FROM users WHERE id = $1`),
+		userID,
+	).Scan(&banned, &reason)
+	return banned, reason, notFound(err)
+}
+
+// Ban keeps a user from logging in or writing anything. Banning a user
+// again only updates the reason.
+func Ban(ctx context.Context, q Querier, userID int, reason string) error {
+	res, err := q.ExecContext(ctx,
+		`UPDATE users SET banned_at = COALESCE(banned_at, now()), ban_reason = $2 WHERE id = $1`,
+		userID,
+		reason,
+	)
+	return affected(res, err)
+}
+
+func Unban(ctx context.Context, q Querier, userID int) error {
+	res, err := q.ExecContext(ctx, `UPDATE users SET banned_at = NULL, ban_reason = '' WHERE id = $1`, userID)
+	return affected(res, err)
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"net/url"
+	"path"
+	"testing"
+)
+
+type row []any
+
+func (r row) Scan(dest ...any) error {
+	for i, v := range r {
+		switch d := dest[i].(type) {
+		case *int:
+			*d = v.(int)
+		case *string:
+			*d = v.(string)
+		case *bool:
+			*d = v.(bool)
+		case *float64:
+			*d = v.(float64)
+		}
+	}
+	return nil
+}
+
+func TestUserRef(t *testing.T) {
+	tests := []struct {
+		ref       string
+		wantWhere string
+		wantArg   any
+	}{
+		{"42", "id = $1", 42},
+		{"alice", "username = $1", "alice"},
+		{"1abc", "username = $1", "1abc"},
+		{"123abc456", "username = $1", "123abc456"},
+	}
+	for _, tt := range tests {
+		where, arg := UserRef(tt.ref)
+		if where != tt.wantWhere || arg != tt.wantArg {
+			t.Errorf("UserRef(%q) = %q, %#v; want %q, %#v", tt.ref, where, arg, tt.wantWhere, tt.wantArg)
+		}
+	}
+}
+
+// The image URL of every user must lead back to that user.
+func TestUserImageURL(t *testing.T) {
+	for _, username := range []string{"alice", "1abc", "2fast4you"} {
+		u, err := ScanUser(row{7, username, true, 1700000000.0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.ImageURL == nil {
+			t.Fatalf("%s: no image URL", username)
+		}
+		parsed, err := url.Parse(*u.ImageURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref := path.Base(path.Dir(parsed.Path))
+		if where, arg := UserRef(ref); where != "username = $1" || arg != username {
+			t.Errorf("%s: image URL %s finds %s with %#v", username, *u.ImageURL, where, arg)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Package validate checks request payloads field by field and collects
+// every violation, so clients can show all problems with a form at once.
+package validate
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"backend/internal/problem"
+)
+
+// A Rule checks a string field and returns a message describing what is
+// wrong with it, or "" if it is fine. Rules may normalise the value in
+// place, so the order in which they are listed matters.
+type Rule func(value *string) string
+
+// Trim removes surrounding whitespace. It never fails.
+func Trim(value *string) string {
+	*value = strings.TrimSpace(*value)
+	return ""
+}
+
+// Required rejects empty values. Combine it with Trim to also reject
+// values made only of whitespace.
+func Required(value *string) string {
+	if *value == "" {
+		return "is required."
+	}
+	return ""
+}
+
+// Length bounds the number of characters (not bytes) in a value. Empty
+// values are left to Required, so optional fields can still be omitted.
+func Length(min, max int) Rule {
+	return func(value *string) string {
+		if *value == "" {
+			return ""
+		}
+		n := utf8.RuneCountInString(*value)
+		if n < min {
+			return fmt.Sprintf("must be at least %d characters.", min)
+		}
+		if n > max {
+			return fmt.Sprintf("must be at most %d characters.", max)
+		}
+		return ""
+	}
+}
+
+// Pattern requires non-empty values to match re. The regexp should be
+// compiled once at package level, not per request.
+func Pattern(re *regexp.Regexp, message string) Rule {
+	return func(value *string) string {
+		if *value != "" && !re.MatchString(*value) {
+			return message
+		}
+		return ""
+	}
+}
+
+// OneOf requires non-empty values to be one of allowed.
+func OneOf(allowed ...string) Rule {
+	return func(value *string) string {
+		if *value != "" && !slices.Contains(allowed, *value) {
+			return "must be one of " + strings.Join(allowed, ", ") + "."
+		}
+		return ""
+	}
+}
+
+// Validator accumulates field errors. The zero value is ready to use.
+type Validator struct {
+	fields []problem.Field
+}
+
+// String applies rules to a field in order and records the first failure.
+func (v *Validator) String(field string, value *string, rules ...Rule) {
+	for _, rule := range rules {
+		if msg := rule(value); msg != "" {
+			v.Add(field, label(field)+" "+msg)
+			return
+		}
+	}
+}
+
+// Check records message for field unless ok holds.
+func (v *Validator) Check(ok bool, field, message string) {
+	if !ok {
+		v.Add(field, message)
+	}
+}
+
+// Add records a violation.
+func (v *Validator) Add(field, message string) {
+	v.fields = append(v.fields, problem.Field{Name: field, Message: message})
+}
+
+// Err returns a validation problem listing every violation, or nil.
+func (v *Validator) Err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return problem.Validation(v.fields...)
+}
+
+// label turns a JSON field name like "post_id" into "Post id".
+func label(field string) string {
+	s := strings.ReplaceAll(field, "_", " ")
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[size:]
+}
@@ -0,0 +1,84 @@
+package validate
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"testing"
+
+	"backend/internal/problem"
+)
+
+var alnum = regexp.MustCompile(`^[a-z0-9]+$`)
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		rules []Rule
+		want  string // the first failure, or ""
+		after string // the value once the rules ran
+	}{
+		{"trim", "  hi \n", []Rule{Trim}, "", "hi"},
+		{"required", "", []Rule{Required}, "is required.", ""},
+		{"required whitespace", "  ", []Rule{Required}, "", "  "},
+		{"trimmed whitespace", "  ", []Rule{Trim, Required}, "is required.", ""},
+		{"too short", "ab", []Rule{Length(3, 5)}, "must be at least 3 characters.", "ab"},
+		{"too long", "abcdef", []Rule{Length(3, 5)}, "must be at most 5 characters.", "abcdef"},
+		{"length in characters", "ééééé", []Rule{Length(3, 5)}, "", "ééééé"},
+		{"empty skips length", "", []Rule{Length(3, 5)}, "", ""},
+		{"length after trim", " ab ", []Rule{Trim, Length(3, 5)}, "must be at least 3 characters.", "ab"},
+		{"pattern", "abc1", []Rule{Pattern(alnum, "bad.")}, "", "abc1"},
+		{"pattern mismatch", "ab-c", []Rule{Pattern(alnum, "bad.")}, "bad.", "ab-c"},
+		{"empty skips pattern", "", []Rule{Pattern(alnum, "bad.")}, "", ""},
+		{"one of", "b", []Rule{OneOf("a", "b")}, "", "b"},
+		{"not one of", "c", []Rule{OneOf("a", "b")}, "must be one of a, b.", "c"},
+		{"empty skips one of", "", []Rule{OneOf("a", "b")}, "", ""},
+		{"first failure", "", []Rule{Required, Length(3, 5)}, "is required.", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value := tt.value
+			got := ""
+			for _, rule := range tt.rules {
+				if got = rule(&value); got != "" {
+					break
+				}
+			}
+			if got != tt.want {
+				t.Errorf("message = %q, want %q", got, tt.want)
+			}
+			if value != tt.after {
+				t.Errorf("value = %q, want %q", value, tt.after)
+			}
+		})
+	}
+}
+
+func TestValidator(t *testing.T) {
+	var v Validator
+	if err := v.Err(); err != nil {
+		t.Fatalf("Err with no violations = %v", err)
+	}
+
+	title, body, postID := "  ", "ok", ""
+	v.String("title", &title, Trim, Required)
+	v.String("body", &body, Required, Length(5, 10))
+	v.String("post_id", &postID, Required)
+	v.Check(true, "topic", "never recorded.")
+	v.Check(false, "version", "Version must be positive.")
+
+	var p *problem.Error
+	if !errors.As(v.Err(), &p) {
+		t.Fatalf("Err = %v, want a *problem.Error", v.Err())
+	}
+	want := []problem.Field{
+		{Name: "title", Message: "Title is required."},
+		{Name: "body", Message: "Body must be at least 5 characters."},
+		{Name: "post_id", Message: "Post id is required."},
+		{Name: "version", Message: "Version must be positive."},
+	}
+	if !slices.Equal(p.Fields, want) {
+		t.Errorf("Fields = %v, want %v", p.Fields, want)
+	}
+}